@@ -3,28 +3,172 @@ package config
 import (
 	"bytes"
 	_ "embed"
+	"github.com/potatowhite/restfulapi/pkg/logging"
 	"github.com/spf13/viper"
-	"log"
+	"log/slog"
 	"strings"
+	"time"
 )
 
 //go:embed config.yaml
 var defaultConfig []byte
 
+// Database configures the connection to Postgres; see database.Options.
 type Database struct {
+	// URL is a complete connection string, which replaces Host, Port,
+	// Username, Password and Dbname.
+	URL      logging.Secret
 	Host     string
 	Port     uint
 	Username string
-	Password string
+	Password logging.Secret
 	Dbname   string
+	// SSLMode is disable, require, verify-ca or verify-full.
+	SSLMode     string `mapstructure:"sslmode"`
+	SSLRootCert string `mapstructure:"sslrootcert"`
+	SSLCert     string `mapstructure:"sslcert"`
+	SSLKey      string `mapstructure:"sslkey"`
+	// ConnectTimeout is rounded up to whole seconds.
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`
+	StatementTimeout time.Duration `mapstructure:"statement_timeout"`
+	ApplicationName  string        `mapstructure:"application_name"`
+	// Pool settings; zero keeps the defaults of database/sql.
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `mapstructure:"conn_max_idle_time"`
+	// Replicas serve the reads of query-only service methods, checked every
+	// ReplicaCheckInterval. For ReadYourWrites after a write, the reads of
+	// the same client go to the primary.
+	Replicas             []Replica
+	ReplicaCheckInterval time.Duration `mapstructure:"replica_check_interval"`
+	ReadYourWrites       time.Duration `mapstructure:"read_your_writes"`
+	// Migrate applies pending schema migrations at startup.
+	Migrate bool
+}
+
+// Replica is a read replica of the primary database. It connects with the
+// settings of the primary, except for its URL or its Host and Port.
+type Replica struct {
+	Name string
+	URL  logging.Secret
+	Host string
+	Port uint
 }
 
 type Server struct {
 	Port string
+	// Read, write and idle timeouts of client connections; see http.Server.
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout"`
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"`
+	// ShutdownDelay is how long the server keeps accepting requests, with
+	// readiness failing, after SIGTERM. ShutdownTimeout is how long
+	// in-flight requests are waited for after that. Together they have to
+	// be less than the grace period of the container.
+	ShutdownDelay   time.Duration `mapstructure:"shutdown_delay"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// ReadinessTimeout bounds each check of /readyz.
+	ReadinessTimeout time.Duration `mapstructure:"readiness_timeout"`
+	// TrustedProxies are the addresses or CIDRs of proxies whose
+	// X-Forwarded-For header is believed when telling clients apart by IP.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
+
+// Auth configures the authentication of the API. Bearer tokens are verified
+// with the first configured of PublicKey, HMACSecret, JWKSFile and JWKSURL;
+// without any, only API keys are accepted.
+type Auth struct {
+	// Enabled requires a valid bearer token or API key on every API request.
+	Enabled bool
+	// APIKeys accepts keys issued through the /api-keys routes in the
+	// X-API-Key header.
+	APIKeys bool `mapstructure:"api_keys"`
+	// Algorithms are the accepted signing algorithms, HS256 for an HMAC
+	// secret and RS256 and ES256 otherwise by default.
+	Algorithms []string
+	Issuer     string
+	Audience   string
+	// Leeway tolerates clock skew between the token issuer and the service.
+	Leeway time.Duration
+	// PublicKey is a PEM encoded RSA or ECDSA public key or certificate.
+	PublicKey  string         `mapstructure:"public_key"`
+	HMACSecret logging.Secret `mapstructure:"hmac_secret"`
+	JWKSFile   string         `mapstructure:"jwks_file"`
+	JWKSURL    string         `mapstructure:"jwks_url"`
+	// JWKSRefresh is how long a JWK Set is cached before it is loaded again.
+	JWKSRefresh time.Duration `mapstructure:"jwks_refresh"`
+	// Roles maps each role, taken from the roles and scope claims of a
+	// token or the scopes of an API key, to the permissions it grants.
+	Roles map[string][]string
+}
+
+// RateLimit configures the per-client rate limiting of API requests.
+type RateLimit struct {
+	Enabled bool
+	// Store keeps the token buckets: "memory" for a single instance, or
+	// "postgres" to share them between replicas.
+	Store string
+	// Default limits every route without a limit of its own; with no
+	// requests set those routes are not limited.
+	Default Limit
+	Routes  []RouteLimit
+	// PerIP limits all API requests from one IP address before they are
+	// authenticated, so floods of invalid credentials are limited too.
+	PerIP Limit `mapstructure:"per_ip"`
+}
+
+// Limit allows Requests every Per on average, and bursts of up to Burst,
+// which defaults to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// RouteLimit limits the route with Method and Path, a route template such
+// as /authors/:id.
+type RouteLimit struct {
+	Method string
+	Path   string
+	Limit  `mapstructure:",squash"`
+}
+
+// Metrics configures the Prometheus metrics served at /metrics.
+type Metrics struct {
+	Enabled bool
+}
+
+// Tracing configures the export of OpenTelemetry traces.
+type Tracing struct {
+	// Exporter is "none", "otlp", "stdout" or "file".
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector.
+	Endpoint string
+	Insecure bool
+	// File receives the spans of the file exporter.
+	File string
+	// SampleRatio is the share of new traces that are recorded.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// Log configures the structured logger.
+type Log struct {
+	// Level is "debug", "info", "warn" or "error".
+	Level string
+	// Format is "json" or "text".
+	Format string
+}
+
 type Config struct {
-	Database Database
-	Server   Server
+	Database  Database
+	Server    Server
+	Auth      Auth
+	RateLimit RateLimit `mapstructure:"rate_limit"`
+	Metrics   Metrics
+	Tracing   Tracing
+	Log       Log
 }
 
 func Read() (*Config, error) {
@@ -32,7 +176,7 @@ func Read() (*Config, error) {
 	viper.SetEnvPrefix("APP")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	host := viper.GetString("database.host")
-	log.Printf("host from env: %s", host)
+	slog.Debug("Database host from the environment", "host", host)
 
 	viper.SetConfigType("yaml")
 	if err := viper.ReadConfig(bytes.NewBuffer(defaultConfig)); err != nil {
@@ -40,7 +184,7 @@ func Read() (*Config, error) {
 	}
 
 	host = viper.GetString("database.host")
-	log.Printf("host from config: %s", host)
+	slog.Debug("Database host from the configuration", "host", host)
 
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
@@ -1,70 +1,377 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/potatowhite/restfulapi/cmd/config"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
 	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/potatowhite/restfulapi/pkg/health"
+	"github.com/potatowhite/restfulapi/pkg/logging"
+	"github.com/potatowhite/restfulapi/pkg/metrics"
+	"github.com/potatowhite/restfulapi/pkg/microservice/apikeys"
 	"github.com/potatowhite/restfulapi/pkg/microservice/authors"
-	"log"
+	"github.com/potatowhite/restfulapi/pkg/openapi"
+	"github.com/potatowhite/restfulapi/pkg/ratelimit"
+	"github.com/potatowhite/restfulapi/pkg/server"
+	"github.com/potatowhite/restfulapi/pkg/tracing"
+	"log/slog"
 	"os"
-)
-
-var (
-	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
+	"strings"
 )
 
 func main() {
 	cfg := loadConfig()
+	initLogging(cfg)
 	db := connectDatabase(cfg)
-	queries := initQueries(db)
-	authorService := initAuthorService(queries)
-	handler := initAuthorHandler(authorService)
-	server := initServer(handler)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(db, os.Args[2:])
+		return
+	}
+	if cfg.Database.Migrate {
+		migrateDatabase(db)
+	}
+	tracer := initTracing(cfg.Tracing)
+	m, wrappers := initMetrics(cfg, db)
+	wrappers = append(wrappers, tracing.DBTX)
+	txManager := initTxManager(db, wrappers)
+	replicas := initReplicas(cfg, m, txManager, wrappers)
+	authorService := initAuthorService(txManager)
+	apiKeyService := initAPIKeyService(txManager)
+	authenticate, policy := initAuth(cfg.Auth, apiKeyService)
+	handler := initAuthorHandler(authorService, policy)
+	var apiKeyHandler apikeys.APIKeyHandler
+	if authenticate != nil && cfg.Auth.APIKeys {
+		apiKeyHandler = initAPIKeyHandler(apiKeyService, policy)
+	}
+	limitIP, limit := initRateLimit(cfg.RateLimit, database.New(database.Wrap(db.DB, wrappers...)))
+	checker := initHealthChecks(cfg.Server, db)
+	router := initRouter(cfg.Server, checker, m, handler, apiKeyHandler, limitIP, authenticate, limit)
 
-	err := server.Run(":" + cfg.Server.Port)
-	if err != nil {
-		log.Fatal(err.Error())
+	srv := server.New(":"+cfg.Server.Port, router, server.Options{
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ShutdownDelay:     cfg.Server.ShutdownDelay,
+		ShutdownTimeout:   cfg.Server.ShutdownTimeout,
+	})
+	srv.OnShutdown(checker.ShuttingDown)
+	if replicas != nil {
+		srv.CloseOnShutdown(replicas)
+	}
+	srv.CloseOnShutdown(db)
+	srv.CloseOnShutdown(tracer)
+	if err := srv.Run(context.Background()); err != nil {
+		fatal("Server stopped", "error", err)
 	}
+	slog.Info("Server stopped")
 }
 
 func loadConfig() *config.Config {
-	logger.Println("Loading configuration...")
+	slog.Info("Loading configuration...")
 	cfg, err := config.Read()
 	if err != nil {
-		logger.Fatalf("Failed to load configuration: %s", err.Error())
+		fatal("Failed to load configuration", "error", err)
 	}
-	logger.Printf("Loaded configuration: %+v", cfg)
 	return cfg
 }
 
+// initLogging makes the configured logger the default one and logs the
+// configuration through it, with its secrets redacted.
+func initLogging(cfg *config.Config) {
+	if err := logging.Setup(logging.Options{Level: cfg.Log.Level, Format: cfg.Log.Format}); err != nil {
+		fatal("Failed to initialize logging", "error", err)
+	}
+	slog.Info("Loaded configuration", "config", cfg)
+}
+
+// fatal logs msg and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func connectDatabase(cfg *config.Config) *database.Postgres {
-	logger.Println("Connecting to database...")
-	db, err := database.NewPostgres(cfg.Database.Host, cfg.Database.Port, cfg.Database.Username, cfg.Database.Password, cfg.Database.Dbname)
+	slog.Info("Connecting to database...")
+	opts := databaseOptions(cfg.Database)
+	if err := opts.Validate(); err != nil {
+		fatal("Invalid database configuration", "error", err)
+	}
+	db, err := database.NewPostgres(opts)
 	if err != nil {
-		logger.Fatalf("Failed to connect to database: %s", err.Error())
+		fatal("Failed to connect to database", "error", err)
 	}
 	return db
 }
 
-func initQueries(db *database.Postgres) *database.Queries {
-	logger.Println("Initializing queries...")
-	queries := database.New(db.DB)
-	return queries
+func databaseOptions(cfg config.Database) database.Options {
+	return database.Options{
+		URL:              string(cfg.URL),
+		Host:             cfg.Host,
+		Port:             cfg.Port,
+		User:             cfg.Username,
+		Password:         string(cfg.Password),
+		Dbname:           cfg.Dbname,
+		SSLMode:          cfg.SSLMode,
+		SSLRootCert:      cfg.SSLRootCert,
+		SSLCert:          cfg.SSLCert,
+		SSLKey:           cfg.SSLKey,
+		ConnectTimeout:   cfg.ConnectTimeout,
+		StatementTimeout: cfg.StatementTimeout,
+		ApplicationName:  cfg.ApplicationName,
+		MaxOpenConns:     cfg.MaxOpenConns,
+		MaxIdleConns:     cfg.MaxIdleConns,
+		ConnMaxLifetime:  cfg.ConnMaxLifetime,
+		ConnMaxIdleTime:  cfg.ConnMaxIdleTime,
+	}
+}
+
+func migrateDatabase(db *database.Postgres) {
+	slog.Info("Migrating database...")
+	migrator, err := database.NewMigrator(db.DB)
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		fatal("Failed to migrate database", "error", err)
+	}
+}
+
+// initMetrics returns the metrics and the wrappers timing the queries, or
+// nils when metrics are disabled.
+func initMetrics(cfg *config.Config, db *database.Postgres) (*metrics.Metrics, []database.Wrapper) {
+	if !cfg.Metrics.Enabled {
+		return nil, nil
+	}
+	slog.Info("Initializing metrics...")
+	m := metrics.New()
+	m.CollectDBStats(db.DB, cfg.Database.Dbname)
+	return m, []database.Wrapper{m.DBTX}
+}
+
+func initTracing(cfg config.Tracing) *tracing.Provider {
+	slog.Info("Initializing tracing...")
+	tracer, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: "authorservice",
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.Endpoint,
+		Insecure:    cfg.Insecure,
+		File:        cfg.File,
+		SampleRatio: cfg.SampleRatio,
+	})
+	if err != nil {
+		fatal("Failed to initialize tracing", "error", err)
+	}
+	return tracer
+}
+
+func initTxManager(db *database.Postgres, wrappers []database.Wrapper) *database.TxManager {
+	slog.Info("Initializing transaction manager...")
+	return database.NewTxManager(db.DB, wrappers...)
+}
+
+// initReplicas routes the reads of txManager to the configured replicas,
+// returning nil when there are none.
+func initReplicas(cfg *config.Config, m *metrics.Metrics, txManager *database.TxManager, wrappers []database.Wrapper) *database.Router {
+	if len(cfg.Database.Replicas) == 0 {
+		return nil
+	}
+	slog.Info("Initializing read replicas...")
+	var replicas []database.Replica
+	for i, r := range cfg.Database.Replicas {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("replica-%d", i+1)
+		}
+		opts := databaseOptions(cfg.Database)
+		switch {
+		case r.URL != "":
+			opts.URL = string(r.URL)
+		case r.Host != "":
+			opts.URL = ""
+			opts.Host = r.Host
+			if r.Port != 0 {
+				opts.Port = r.Port
+			}
+		default:
+			fatal("Invalid database configuration", "error", fmt.Sprintf("replica %s has neither a url nor a host", name))
+		}
+		if err := opts.Validate(); err != nil {
+			fatal("Invalid database configuration", "replica", name, "error", err)
+		}
+		// a replica that is down at startup is skipped until it is up
+		replica, err := database.OpenPostgres(opts)
+		if err != nil {
+			fatal("Failed to open replica", "replica", name, "error", err)
+		}
+		if m != nil {
+			m.CollectDBStats(replica.DB, name)
+		}
+		replicas = append(replicas, database.Replica{Name: name, DB: replica.DB})
+	}
+	router := database.NewRouter(txManager.Queries(), replicas, database.RouterOptions{
+		CheckInterval:  cfg.Database.ReplicaCheckInterval,
+		ReadYourWrites: cfg.Database.ReadYourWrites,
+	}, wrappers...)
+	router.Start()
+	txManager.ReadFrom(router)
+	return router
+}
+
+func initAuthorService(txManager *database.TxManager) authors.AuthorService {
+	slog.Info("Initializing author service...")
+	return authors.WithTracing(authors.NewAuthorService(txManager))
+}
+
+func initAuthorHandler(authorService authors.AuthorService, policy *auth.Policy) authors.AuthorHandler {
+	slog.Info("Initializing author handler...")
+	return authors.NewAuthorHandler(authorService, policy)
+}
+
+func initAPIKeyService(txManager *database.TxManager) apikeys.APIKeyService {
+	slog.Info("Initializing API key service...")
+	return apikeys.NewAPIKeyService(txManager)
+}
+
+func initAPIKeyHandler(apiKeyService apikeys.APIKeyService, policy *auth.Policy) apikeys.APIKeyHandler {
+	slog.Info("Initializing API key handler...")
+	return apikeys.NewAPIKeyHandler(apiKeyService, policy)
+}
+
+// initAuth returns the authentication middleware and the authorization
+// policy, or nils when authentication is disabled.
+func initAuth(cfg config.Auth, apiKeys auth.APIKeys) (gin.HandlerFunc, *auth.Policy) {
+	if !cfg.Enabled {
+		slog.Info("Authentication is disabled, the API is open to anyone")
+		return nil, nil
+	}
+	slog.Info("Initializing authentication...")
+
+	var keys auth.KeySource
+	var verifier *auth.Verifier
+	switch {
+	case cfg.PublicKey != "":
+		var err error
+		if keys, err = auth.NewPEMKeySource([]byte(cfg.PublicKey)); err != nil {
+			fatal("Failed to load the public key", "error", err)
+		}
+	case cfg.HMACSecret != "":
+		keys = auth.NewHMACKeySource([]byte(cfg.HMACSecret))
+	case cfg.JWKSFile != "":
+		keys = auth.NewJWKSFileSource(cfg.JWKSFile, cfg.JWKSRefresh)
+	case cfg.JWKSURL != "":
+		keys = auth.NewJWKSURLSource(cfg.JWKSURL, cfg.JWKSRefresh, nil)
+	case cfg.APIKeys:
+		slog.Info("No key source is configured, only API keys are accepted")
+	default:
+		fatal("Authentication is enabled but no key source is configured")
+	}
+	if keys != nil {
+		if err := auth.CheckAlgorithms(keys, cfg.Algorithms); err != nil {
+			fatal("Invalid auth.algorithms", "error", err)
+		}
+		verifier = auth.NewVerifier(keys, auth.VerifierOptions{
+			Algorithms: cfg.Algorithms,
+			Issuer:     cfg.Issuer,
+			Audience:   cfg.Audience,
+			Leeway:     cfg.Leeway,
+		})
+	}
+	if !cfg.APIKeys {
+		apiKeys = nil
+	}
+
+	return auth.Middleware(verifier, apiKeys), auth.NewPolicy(cfg.Roles)
+}
+
+// initRateLimit returns the rate limiting middlewares running before and
+// after authentication, or nils when rate limiting is disabled.
+func initRateLimit(cfg config.RateLimit, queries *database.Queries) (gin.HandlerFunc, gin.HandlerFunc) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	slog.Info("Initializing rate limiting...")
+
+	limit := func(l config.Limit, name string) ratelimit.Limit {
+		if l.Requests > 0 && l.Per <= 0 {
+			fatal("Rate limit allows requests per no time", "route", name, "requests", l.Requests, "per", l.Per)
+		}
+		return ratelimit.Limit{Requests: l.Requests, Per: l.Per, Burst: l.Burst}
+	}
+	limits := ratelimit.Limits{Default: limit(cfg.Default, "the default route"), Routes: map[string]ratelimit.Limit{}}
+	for _, route := range cfg.Routes {
+		name := strings.ToUpper(route.Method) + " " + route.Path
+		limits.Routes[name] = limit(route.Limit, name)
+	}
+
+	var store ratelimit.Store
+	switch cfg.Store {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "postgres":
+		store = ratelimit.NewPostgresStore(queries)
+	default:
+		fatal("Unknown rate limit store", "store", cfg.Store)
+	}
+	return ratelimit.PerIP(store, limit(cfg.PerIP, "per_ip")), ratelimit.Middleware(store, limits)
 }
 
-func initAuthorService(queries *database.Queries) authors.AuthorService {
-	logger.Println("Initializing author service...")
-	return authors.NewAuthorService(queries)
+func initHealthChecks(cfg config.Server, db *database.Postgres) *health.Checker {
+	slog.Info("Initializing health checks...")
+	migrator, err := database.NewMigrator(db.DB)
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+	checker := health.NewChecker(cfg.ReadinessTimeout)
+	checker.Add("database", health.Ping(db.DB))
+	checker.Add("migrations", health.Migrations(migrator))
+	return checker
 }
 
-func initAuthorHandler(authorService authors.AuthorService) authors.AuthorHandler {
-	logger.Println("Initializing author handler...")
-	return authors.NewAuthorHandler(authorService)
+// identifyClient attributes the context of an API request to its client,
+// told apart like for rate limiting, so only the client that wrote reads
+// its writes from the primary.
+func identifyClient(c *gin.Context) {
+	c.Request = c.Request.WithContext(database.WithClient(c.Request.Context(), ratelimit.ClientKey(c)))
 }
 
-func initServer(handler authors.AuthorHandler) *gin.Engine {
-	logger.Println("Initializing server...")
-	router := gin.Default()
-	handler.RegisterHandlers(router)
+func initRouter(cfg config.Server, checker *health.Checker, m *metrics.Metrics, handler authors.AuthorHandler, apiKeyHandler apikeys.APIKeyHandler, limitIP gin.HandlerFunc, authenticate gin.HandlerFunc, limit gin.HandlerFunc) *gin.Engine {
+	slog.Info("Initializing router...")
+	spec, err := openapi.Parse(authors.Spec)
+	if err != nil {
+		fatal("Failed to load the API description", "error", err)
+	}
+	router := gin.New()
+	router.Use(gin.Recovery(), logging.Middleware(), tracing.Middleware())
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fatal("Failed to set the trusted proxies", "error", err)
+	}
+	if m != nil {
+		router.Use(m.Middleware())
+		m.RegisterHandlers(router)
+	}
+	router.Use(apperror.Middleware())
+	checker.RegisterHandlers(router)
+	openapi.RegisterHandlers(router, authors.Spec)
+
+	api := router.Group("")
+	if limitIP != nil {
+		api.Use(limitIP)
+	}
+	if authenticate != nil {
+		api.Use(authenticate)
+	}
+	if limit != nil {
+		api.Use(limit)
+	}
+	api.Use(identifyClient)
+	api.Use(openapi.NewValidator(spec, openapi.ValidatorOptions{MaxBodyBytes: authors.MaxRequestBytes}).Requests())
+	handler.RegisterHandlers(api)
+	if apiKeyHandler != nil {
+		apiKeyHandler.RegisterHandlers(api)
+	}
 	return router
 }
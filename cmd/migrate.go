@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"log/slog"
+	"strconv"
+)
+
+const migrateUsage = "usage: service migrate up | down [steps] | version"
+
+// runMigrateCommand implements `service migrate ...`, for running migrations
+// as a separate deployment step instead of at startup.
+func runMigrateCommand(db *database.Postgres, args []string) {
+	migrator, err := database.NewMigrator(db.DB)
+	if err != nil {
+		fatal("Failed to load migrations", "error", err)
+	}
+
+	ctx := context.Background()
+	if len(args) == 0 {
+		fatal(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fatal(migrateUsage)
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case "version":
+		var version int64
+		if version, err = migrator.Version(ctx); err == nil {
+			slog.Info("Schema version", "version", version)
+		}
+	default:
+		fatal(migrateUsage)
+	}
+	if err != nil {
+		fatal("Migration failed", "error", err)
+	}
+}
@@ -0,0 +1,22 @@
+// Package actor carries the identity of whoever is performing a request
+// through context.Context, so layers below the HTTP handlers can attribute
+// changes without knowing how the caller was authenticated.
+package actor
+
+import "context"
+
+// Anonymous is reported for requests that carry no identity.
+const Anonymous = "anonymous"
+
+type contextKey struct{}
+
+func With(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+func From(ctx context.Context) string {
+	if name, ok := ctx.Value(contextKey{}).(string); ok && name != "" {
+		return name
+	}
+	return Anonymous
+}
@@ -0,0 +1,135 @@
+// Package apperror defines the domain errors returned by the services and
+// their translation into RFC 7807 problem responses.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind classifies an error by what went wrong from the caller's point of
+// view. Each kind maps to one HTTP status.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindNotFound
+	KindConflict
+	KindPreconditionFailed
+	KindPreconditionRequired
+	KindNotApplied
+	KindUnprocessable
+	KindRetryable
+	KindUnauthenticated
+	KindForbidden
+	KindTooManyRequests
+	KindRequestTooLarge
+)
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error. Code is a stable, machine readable identifier and
+// Message is safe to show to clients; Err is the underlying cause, which is
+// only ever logged.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NotFound(code string, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+func Conflict(code string, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+func Validation(code string, message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message, Fields: fields}
+}
+
+func PreconditionFailed(code string, message string) *Error {
+	return &Error{Kind: KindPreconditionFailed, Code: code, Message: message}
+}
+
+func PreconditionRequired(code string, message string) *Error {
+	return &Error{Kind: KindPreconditionRequired, Code: code, Message: message}
+}
+
+func NotApplied(code string, message string) *Error {
+	return &Error{Kind: KindNotApplied, Code: code, Message: message}
+}
+
+// Unprocessable reports a well-formed request whose values the data store
+// rejected, e.g. because they break a check constraint.
+func Unprocessable(code string, message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindUnprocessable, Code: code, Message: message, Fields: fields}
+}
+
+// Retryable reports a transient failure after which the same request may
+// succeed.
+func Retryable(code string, message string) *Error {
+	return &Error{Kind: KindRetryable, Code: code, Message: message}
+}
+
+// Unauthenticated reports a request without valid credentials.
+func Unauthenticated(code string, message string) *Error {
+	return &Error{Kind: KindUnauthenticated, Code: code, Message: message}
+}
+
+// Forbidden reports an authenticated caller lacking permission.
+func Forbidden(code string, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// TooManyRequests reports a caller that exceeded its rate limit.
+func TooManyRequests(code string, message string) *Error {
+	return &Error{Kind: KindTooManyRequests, Code: code, Message: message}
+}
+
+// RequestTooLarge reports a request body over the size the server accepts.
+func RequestTooLarge(code string, message string) *Error {
+	return &Error{Kind: KindRequestTooLarge, Code: code, Message: message}
+}
+
+// Internal hides err from clients behind a generic message.
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal", Message: "internal server error", Err: err}
+}
+
+// WithCause returns a copy of e caused by err.
+func (e *Error) WithCause(err error) *Error {
+	withCause := *e
+	withCause.Err = err
+	return &withCause
+}
+
+// As returns err as a domain error. Errors that are not domain errors are
+// treated as internal ones.
+func As(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err)
+}
@@ -0,0 +1,171 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const ProblemContentType = "application/problem+json"
+
+var statuses = map[Kind]int{
+	KindInternal:             http.StatusInternalServerError,
+	KindValidation:           http.StatusBadRequest,
+	KindNotFound:             http.StatusNotFound,
+	KindConflict:             http.StatusConflict,
+	KindPreconditionFailed:   http.StatusPreconditionFailed,
+	KindPreconditionRequired: http.StatusPreconditionRequired,
+	KindNotApplied:           http.StatusFailedDependency,
+	KindUnprocessable:        http.StatusUnprocessableEntity,
+	KindRetryable:            http.StatusServiceUnavailable,
+	KindUnauthenticated:      http.StatusUnauthorized,
+	KindForbidden:            http.StatusForbidden,
+	KindTooManyRequests:      http.StatusTooManyRequests,
+	KindRequestTooLarge:      http.StatusRequestEntityTooLarge,
+}
+
+// retryAfter is the Retry-After hint, in seconds, sent with retryable errors.
+const retryAfter = "1"
+
+// Problem is an RFC 7807 problem details document, extended with a stable
+// error code and per-field validation errors.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// Status returns the HTTP status for err.
+func Status(err error) int {
+	return statuses[As(err).Kind]
+}
+
+// NewProblem describes err as a problem that occurred on instance.
+func NewProblem(err error, instance string) Problem {
+	appErr := As(err)
+	status := statuses[appErr.Kind]
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   appErr.Message,
+		Instance: instance,
+		Code:     appErr.Code,
+		Errors:   appErr.Fields,
+	}
+}
+
+// Middleware renders the last error a handler attached with c.Error as an
+// application/problem+json response, unless the handler already wrote one.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		problem := NewProblem(err, c.Request.URL.Path)
+		switch problem.Status {
+		case http.StatusInternalServerError:
+			slog.ErrorContext(c.Request.Context(), "request failed", "method", c.Request.Method, "path", c.Request.URL.Path, "error", err)
+		case http.StatusServiceUnavailable:
+			c.Header("Retry-After", retryAfter)
+		}
+		Write(c, problem)
+	}
+}
+
+// Write sends problem as the response.
+func Write(c *gin.Context, problem Problem) {
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// FromBinding turns an error from gin's ShouldBind* methods into a validation
+// error listing every rejected field.
+func FromBinding(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, len(validationErrs))
+		for i, fe := range validationErrs {
+			fields[i] = FieldError{
+				Field:   fe.Field(),
+				Code:    fe.Tag(),
+				Message: fieldMessage(fe),
+			}
+		}
+		return Validation("invalid_request", "the request is invalid", fields...)
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return Validation("malformed_body", "the request body is not valid JSON")
+	case errors.As(err, &typeErr):
+		return Validation("invalid_request", "the request is invalid", FieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be of type " + typeErr.Type.String(),
+		})
+	}
+	if field, ok := unknownField(err); ok {
+		return Validation("invalid_request", "the request is invalid", FieldError{
+			Field:   field,
+			Code:    "unknown",
+			Message: "is not a known field",
+		})
+	}
+	return Validation("invalid_request", "the request is invalid")
+}
+
+// unknownField returns the field named by the error a json.Decoder that
+// disallows unknown fields returns for one, which has no type of its own.
+func unknownField(err error) (string, bool) {
+	quoted, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+	if !ok {
+		return "", false
+	}
+	field, unquoteErr := strconv.Unquote(quoted)
+	return field, unquoteErr == nil
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return "must be at most " + fe.Param() + " long"
+	case "min":
+		return "must be at least " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	}
+	return "failed the " + fe.Tag() + " check"
+}
+
+func init() {
+	// report fields by the names clients use rather than Go struct fields
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			for _, tag := range []string{"json", "form", "uri"} {
+				if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" && name != "-" {
+					return name
+				}
+			}
+			return field.Name
+		})
+	}
+}
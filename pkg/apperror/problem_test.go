@@ -0,0 +1,102 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewProblem_HidesInternalErrors(t *testing.T) {
+	problem := NewProblem(errors.New(`pq: relation "authors" does not exist`), "/authors")
+
+	require.Equal(t, Problem{
+		Type:     "about:blank",
+		Title:    "Internal Server Error",
+		Status:   http.StatusInternalServerError,
+		Detail:   "internal server error",
+		Instance: "/authors",
+		Code:     "internal",
+	}, problem)
+}
+
+func TestNewProblem_UnwrapsDomainErrors(t *testing.T) {
+	err := fmt.Errorf("error updating author: %w", PreconditionFailed("version_mismatch", "author has been modified"))
+
+	problem := NewProblem(err, "")
+
+	require.Equal(t, http.StatusPreconditionFailed, problem.Status)
+	require.Equal(t, "version_mismatch", problem.Code)
+	require.Equal(t, "author has been modified", problem.Detail)
+}
+
+func TestFromBinding_ListsFields(t *testing.T) {
+	var request struct {
+		Name string `json:"name" binding:"required,max=3"`
+		Bio  string `json:"bio" binding:"required"`
+	}
+	request.Name = "too long"
+
+	err := FromBinding(binding.Validator.ValidateStruct(&request))
+
+	require.Equal(t, KindValidation, err.Kind)
+	require.Equal(t, []FieldError{
+		{Field: "name", Code: "max", Message: "must be at most 3 long"},
+		{Field: "bio", Code: "required", Message: "is required"},
+	}, err.Fields)
+}
+
+func TestFromBinding_MalformedJSON(t *testing.T) {
+	var v map[string]string
+	err := FromBinding(json.Unmarshal([]byte("{"), &v))
+
+	require.Equal(t, "malformed_body", err.Code)
+}
+
+func TestFromBinding_UnknownField(t *testing.T) {
+	var v struct {
+		Name string `json:"name"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(`{"name":"x","color":"red"}`))
+	decoder.DisallowUnknownFields()
+
+	err := FromBinding(decoder.Decode(&v))
+
+	require.Equal(t, "invalid_request", err.Code)
+	require.Equal(t, []FieldError{{Field: "color", Code: "unknown", Message: "is not a known field"}}, err.Fields)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/missing", func(c *gin.Context) {
+		_ = c.Error(NotFound("author_not_found", "author not found"))
+	})
+	router.GET("/written", func(c *gin.Context) {
+		_ = c.Error(errors.New("already handled"))
+		c.String(http.StatusOK, "ok")
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, ProblemContentType, rec.Header().Get("Content-Type"))
+	var problem Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, "author_not_found", problem.Code)
+	require.Equal(t, "/missing", problem.Instance)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/written", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "ok", rec.Body.String())
+}
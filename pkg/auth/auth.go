@@ -0,0 +1,168 @@
+// Package auth authenticates API requests with JWT bearer tokens or API keys.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/potatowhite/restfulapi/pkg/actor"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"strings"
+	"time"
+)
+
+// DefaultAlgorithms are the signing algorithms accepted from public keys
+// when none are configured; HMAC secrets default to HS256.
+var DefaultAlgorithms = []string{"RS256", "ES256"}
+
+var (
+	ErrMissingToken = apperror.Unauthenticated("missing_token", "a bearer token is required")
+	ErrInvalidToken = apperror.Unauthenticated("invalid_token", "the bearer token is invalid")
+	// ErrInvalidAPIKey is returned for API keys that are unknown, revoked or
+	// expired, which callers cannot tell apart.
+	ErrInvalidAPIKey = apperror.Unauthenticated("invalid_api_key", "the API key is invalid")
+)
+
+// APIKeyHeader carries the API key of clients that cannot use bearer
+// tokens.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys resolves API keys to the principal they were issued to.
+type APIKeys interface {
+	// Authenticate returns ErrInvalidAPIKey for keys that do not grant
+	// access.
+	Authenticate(ctx context.Context, key string) (*Principal, error)
+}
+
+// Claims are the verified claims of a token. The caller's roles are taken
+// from both the roles claim and the space separated scope claim.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles jwt.ClaimStrings `json:"roles,omitempty"`
+	Scope string           `json:"scope,omitempty"`
+}
+
+// Principal returns the caller the claims identify.
+func (c *Claims) Principal() *Principal {
+	roles := append([]string{}, c.Roles...)
+	return &Principal{Subject: c.Subject, Roles: append(roles, strings.Fields(c.Scope)...)}
+}
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFrom returns the claims of the authenticated caller, if any.
+func ClaimsFrom(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+type VerifierOptions struct {
+	// Algorithms restricts the accepted signing algorithms; tokens signed
+	// otherwise are rejected before any key is looked up. The default
+	// depends on the key source, see DefaultAlgorithms.
+	Algorithms []string
+	// Issuer and Audience are required to match when set.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// Verifier checks the signature and the registered claims of tokens.
+type Verifier struct {
+	keys   KeySource
+	parser *jwt.Parser
+}
+
+func NewVerifier(keys KeySource, opts VerifierOptions) *Verifier {
+	algorithms := opts.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = defaultAlgorithms(keys)
+	}
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	return &Verifier{keys: keys, parser: jwt.NewParser(parserOpts...)}
+}
+
+// Verify returns the claims of token if it is validly signed and current.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	var claims Claims
+	_, err := v.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid, t.Method.Alg())
+	})
+	if err != nil {
+		return nil, ErrInvalidToken.WithCause(err)
+	}
+	return &claims, nil
+}
+
+// Middleware requires a valid bearer token in the Authorization header, or,
+// when keys is not nil, a valid API key in the X-API-Key header. Without a
+// verifier only API keys are accepted. The
+// principal the credentials identify is put on the request context, along
+// with the verified claims of a token, and its subject is recorded as the
+// actor of the request.
+func Middleware(v *Verifier, keys APIKeys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" && keys != nil {
+			principal, err := keys.Authenticate(c.Request.Context(), key)
+			if err != nil {
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+			ctx := WithPrincipal(c.Request.Context(), principal)
+			c.Request = c.Request.WithContext(actor.With(ctx, principal.Subject))
+			return
+		}
+
+		token, err := bearerToken(c.GetHeader("Authorization"))
+		if err == nil && v == nil {
+			err = ErrInvalidToken.WithCause(fmt.Errorf("bearer tokens are not accepted"))
+		}
+		if err == nil {
+			var claims *Claims
+			if claims, err = v.Verify(c.Request.Context(), token); err == nil {
+				ctx := WithPrincipal(WithClaims(c.Request.Context(), claims), claims.Principal())
+				c.Request = c.Request.WithContext(actor.With(ctx, claims.Subject))
+				return
+			}
+		}
+
+		// RFC 6750 section 3
+		challenge := `Bearer realm="api"`
+		if apperror.As(err).Code == ErrInvalidToken.Code {
+			challenge += `, error="invalid_token"`
+		}
+		c.Header("WWW-Authenticate", challenge)
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
+
+func bearerToken(header string) (string, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		if header == "" {
+			return "", ErrMissingToken
+		}
+		return "", ErrInvalidToken.WithCause(fmt.Errorf("authorization header is not a bearer token"))
+	}
+	return strings.TrimSpace(token), nil
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/potatowhite/restfulapi/pkg/actor"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret    = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "alice",
+		Issuer:    "https://issuer.test",
+		Audience:  jwt.ClaimStrings{"authors"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func publicKeyPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func jwksJSON(t *testing.T, keys map[string]interface{}) []byte {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	var set jwkSet
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, Alg: "RS256", Use: "sig", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Alg: "ES256", Crv: "P-256", X: b64(key.X.Bytes()), Y: b64(key.Y.Bytes())})
+		}
+	}
+	b, err := json.Marshal(set)
+	require.NoError(t, err)
+	return b
+}
+
+func TestVerifier_PEM(t *testing.T) {
+	rsaKeys, err := NewPEMKeySource(publicKeyPEM(t, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	ecKeys, err := NewPEMKeySource(publicKeyPEM(t, &ecKey.PublicKey))
+	require.NoError(t, err)
+	opts := VerifierOptions{Issuer: "https://issuer.test", Audience: "authors"}
+
+	claims, err := NewVerifier(rsaKeys, opts).Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Subject)
+
+	claims, err = NewVerifier(ecKeys, opts).Verify(context.Background(), sign(t, jwt.SigningMethodES256, ecKey, "", validClaims()))
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Subject)
+}
+
+func TestVerifier_HMAC(t *testing.T) {
+	v := NewVerifier(NewHMACKeySource(secret), VerifierOptions{Algorithms: []string{"HS256"}})
+
+	claims, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, secret, "", validClaims()))
+
+	require.NoError(t, err)
+	require.Equal(t, "alice", claims.Subject)
+}
+
+func TestVerifier_DefaultAlgorithmsFitTheKey(t *testing.T) {
+	v := NewVerifier(NewHMACKeySource(secret), VerifierOptions{})
+
+	_, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, secret, "", validClaims()))
+
+	require.NoError(t, err)
+}
+
+func TestCheckAlgorithms(t *testing.T) {
+	rsaKeys, err := NewPEMKeySource(publicKeyPEM(t, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	hmac := NewHMACKeySource(secret)
+
+	require.NoError(t, CheckAlgorithms(hmac, nil))
+	require.NoError(t, CheckAlgorithms(rsaKeys, nil))
+	require.NoError(t, CheckAlgorithms(rsaKeys, []string{"RS256", "ES256"}))
+	require.NoError(t, CheckAlgorithms(NewJWKSFileSource("jwks.json", time.Minute), []string{"HS256"}))
+	require.EqualError(t, CheckAlgorithms(hmac, []string{"RS256", "ES256"}), "an HMAC secret cannot verify any of the algorithms RS256, ES256")
+	require.Error(t, CheckAlgorithms(rsaKeys, []string{"HS256"}))
+}
+
+func TestVerifier_RejectsInvalidTokens(t *testing.T) {
+	keys, err := NewPEMKeySource(publicKeyPEM(t, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	v := NewVerifier(keys, VerifierOptions{Issuer: "https://issuer.test", Audience: "authors", Algorithms: []string{"RS256", "HS256"}})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"billing"}
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://evil.test"
+
+	tests := map[string]string{
+		"expired":        sign(t, jwt.SigningMethodRS256, rsaKey, "", expired),
+		"no expiry":      sign(t, jwt.SigningMethodRS256, rsaKey, "", noExpiry),
+		"wrong audience": sign(t, jwt.SigningMethodRS256, rsaKey, "", wrongAudience),
+		"wrong issuer":   sign(t, jwt.SigningMethodRS256, rsaKey, "", wrongIssuer),
+		"wrong key":      sign(t, jwt.SigningMethodRS256, otherKey, "", validClaims()),
+		// the public key must never be usable as an HMAC secret
+		"algorithm confusion":  sign(t, jwt.SigningMethodHS256, publicKeyPEM(t, &rsaKey.PublicKey), "", validClaims()),
+		"disallowed algorithm": sign(t, jwt.SigningMethodES256, ecKey, "", validClaims()),
+		"garbage":              "not.a.token",
+	}
+	for name, token := range tests {
+		_, err := v.Verify(context.Background(), token)
+		require.Error(t, err, name)
+		require.Equal(t, ErrInvalidToken.Code, apperror.As(err).Code, name)
+	}
+}
+
+func TestJWKSFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksJSON(t, map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}), 0o600))
+	v := NewVerifier(NewJWKSFileSource(path, time.Minute), VerifierOptions{})
+
+	_, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", validClaims()))
+	require.NoError(t, err)
+	_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodES256, ecKey, "ec", validClaims()))
+	require.NoError(t, err)
+
+	// a key may only verify the algorithm it is published for
+	_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodES256, ecKey, "rsa", validClaims()))
+	require.Error(t, err)
+	_, err = v.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, "unknown", validClaims()))
+	require.Error(t, err)
+}
+
+func TestJWKSURLSource_CachesAndPicksUpRotatedKeys(t *testing.T) {
+	var (
+		fetches atomic.Int32
+		current atomic.Value
+	)
+	current.Store(jwksJSON(t, map[string]interface{}{"one": &rsaKey.PublicKey}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(current.Load().([]byte))
+	}))
+	defer server.Close()
+
+	source := NewJWKSURLSource(server.URL, time.Hour, server.Client()).(*jwks)
+	now := time.Now()
+	source.now = func() time.Time { return now }
+	v := NewVerifier(source, VerifierOptions{})
+
+	for i := 0; i < 3; i++ {
+		_, err := v.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, rsaKey, "one", validClaims()))
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 1, fetches.Load())
+
+	// the issuer rotates to a new key
+	current.Store(jwksJSON(t, map[string]interface{}{"one": &rsaKey.PublicKey, "two": &ecKey.PublicKey}))
+	rotated := sign(t, jwt.SigningMethodES256, ecKey, "two", validClaims())
+
+	// unknown key ids do not refetch more often than jwksMinRefresh
+	_, err := v.Verify(context.Background(), rotated)
+	require.Error(t, err)
+	require.EqualValues(t, 1, fetches.Load())
+
+	now = now.Add(jwksMinRefresh)
+	_, err = v.Verify(context.Background(), rotated)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, fetches.Load())
+}
+
+func TestJWKS_ThrottlesLoadsWhileTheSourceIsDown(t *testing.T) {
+	var fetches atomic.Int32
+	source := newJWKS(func(context.Context) ([]byte, error) {
+		fetches.Add(1)
+		return nil, errors.New("connection refused")
+	}, time.Hour)
+	now := time.Now()
+	source.now = func() time.Time { return now }
+	v := NewVerifier(source, VerifierOptions{})
+	token := sign(t, jwt.SigningMethodRS256, rsaKey, "one", validClaims())
+
+	for i := 0; i < 3; i++ {
+		_, err := v.Verify(context.Background(), token)
+		require.Error(t, err)
+	}
+	require.EqualValues(t, 1, fetches.Load())
+
+	now = now.Add(jwksMinRefresh)
+	_, err := v.Verify(context.Background(), token)
+	require.Error(t, err)
+	require.EqualValues(t, 2, fetches.Load())
+}
+
+func TestJWKS_RefreshesWithoutBlockingRequests(t *testing.T) {
+	set := jwksJSON(t, map[string]interface{}{"one": &rsaKey.PublicKey})
+	refreshing, release := make(chan struct{}), make(chan struct{})
+	var fetches atomic.Int32
+	source := newJWKS(func(context.Context) ([]byte, error) {
+		if fetches.Add(1) > 1 {
+			close(refreshing)
+			<-release
+		}
+		return set, nil
+	}, time.Minute)
+	now := time.Now()
+	source.now = func() time.Time { return now }
+	v := NewVerifier(source, VerifierOptions{})
+	token := sign(t, jwt.SigningMethodRS256, rsaKey, "one", validClaims())
+
+	_, err := v.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// the refresh hangs, yet requests go on with the cached keys
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		_, err = v.Verify(context.Background(), token)
+		require.NoError(t, err)
+	}
+	<-refreshing
+	require.EqualValues(t, 2, fetches.Load())
+	close(release)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(Middleware(NewVerifier(NewHMACKeySource(secret), VerifierOptions{Algorithms: []string{"HS256"}}), nil))
+	router.GET("/whoami", func(c *gin.Context) {
+		claims, ok := ClaimsFrom(c.Request.Context())
+		require.True(t, ok)
+		c.String(http.StatusOK, claims.Subject+" "+actor.From(c.Request.Context()))
+	})
+
+	send := func(authorization string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send("Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", validClaims()))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "alice alice", rec.Body.String())
+
+	rec = send("")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.Equal(t, `Bearer realm="api"`, rec.Header().Get("WWW-Authenticate"))
+	require.Equal(t, apperror.ProblemContentType, rec.Header().Get("Content-Type"))
+
+	for _, authorization := range []string{"Basic YWxpY2U6c2VjcmV0", "Bearer not.a.token"} {
+		rec = send(authorization)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, authorization)
+		require.Equal(t, `Bearer realm="api", error="invalid_token"`, rec.Header().Get("WWW-Authenticate"), authorization)
+	}
+}
+
+type apiKeys map[string]*Principal
+
+func (k apiKeys) Authenticate(_ context.Context, key string) (*Principal, error) {
+	if principal, ok := k[key]; ok {
+		return principal, nil
+	}
+	return nil, ErrInvalidAPIKey
+}
+
+func TestMiddleware_APIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	keys := apiKeys{"valid": {Subject: "batch-importer", Roles: []string{"editor"}}}
+	router.Use(Middleware(NewVerifier(NewHMACKeySource(secret), VerifierOptions{Algorithms: []string{"HS256"}}), keys))
+	router.GET("/whoami", func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c.Request.Context())
+		require.True(t, ok)
+		c.String(http.StatusOK, principal.Subject+" "+actor.From(c.Request.Context()))
+	})
+
+	send := func(key string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		request.Header.Set(APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send("valid")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "batch-importer batch-importer", rec.Body.String())
+
+	rec = send("revoked")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	var problem apperror.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, ErrInvalidAPIKey.Code, problem.Code)
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultJWKSRefresh = 15 * time.Minute
+	// jwksMinRefresh limits how often tokens with unknown key ids can make
+	// the key set be fetched again.
+	jwksMinRefresh = 30 * time.Second
+)
+
+// jwk is a JSON Web Key as defined by RFC 7517 and RFC 7518, limited to the
+// members needed for RSA, EC and symmetric verification keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type verificationKey struct {
+	alg string
+	key interface{}
+}
+
+// parseJWKS parses a JWK Set into its verification keys by key id. Keys for
+// other uses than signatures and keys of unknown types are skipped.
+func parseJWKS(data []byte) (map[string]verificationKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS: %w", err)
+	}
+	keys := map[string]verificationKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing JWK %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			keys[k.Kid] = verificationKey{alg: k.Alg, key: key}
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// jwks is a key source backed by a JWK Set that is loaded by fetch and kept
+// for refresh. A token with an unknown key id triggers an early reload, so
+// keys added by a rotation are picked up without waiting. Loads run in the
+// background, one at a time and at most every jwksMinRefresh; requests only
+// wait for one when the keys they need are missing.
+type jwks struct {
+	fetch   func(ctx context.Context) ([]byte, error)
+	refresh time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]verificationKey
+	// attemptedAt is when the last load started and err why it failed
+	attemptedAt time.Time
+	err         error
+	loading     chan struct{}
+}
+
+// NewJWKSFileSource verifies tokens with the keys of the JWK Set in the file
+// at path, which is read again every refresh.
+func NewJWKSFileSource(path string, refresh time.Duration) KeySource {
+	return newJWKS(func(context.Context) ([]byte, error) { return os.ReadFile(path) }, refresh)
+}
+
+// NewJWKSURLSource verifies tokens with the keys of the JWK Set served at
+// url, which is fetched again every refresh.
+func NewJWKSURLSource(url string, refresh time.Duration, client *http.Client) KeySource {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s returned %s", url, res.Status)
+		}
+		return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	}, refresh)
+}
+
+func newJWKS(fetch func(ctx context.Context) ([]byte, error), refresh time.Duration) *jwks {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &jwks{fetch: fetch, refresh: refresh, now: time.Now}
+}
+
+func (s *jwks) Key(ctx context.Context, kid string, alg string) (interface{}, error) {
+	s.mu.Lock()
+	key, ok := s.lookup(kid)
+	age := s.now().Sub(s.attemptedAt)
+	if age >= s.refresh || (!ok && age >= jwksMinRefresh) {
+		loading := s.load()
+		if !ok {
+			s.mu.Unlock()
+			select {
+			case <-loading:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			s.mu.Lock()
+			key, ok = s.lookup(kid)
+		}
+	}
+	keys, err := s.keys, s.err
+	s.mu.Unlock()
+
+	if !ok {
+		if keys == nil && err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: unknown key id %q", errUnknownKey, kid)
+	}
+	if (key.alg != "" && key.alg != alg) || !keyFits(key.key, alg) {
+		return nil, fmt.Errorf("%w: key %q cannot verify %s", errUnknownKey, kid, alg)
+	}
+	return key.key, nil
+}
+
+// lookup finds the key with kid. Tokens without a key id can only be
+// verified by a set holding a single key.
+func (s *jwks) lookup(kid string) (verificationKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// load starts loading the key set unless a load is running already, and
+// returns a channel closed once it is done. It has to be called with s.mu
+// held. The load does not belong to any one request, so it is not canceled
+// with one.
+func (s *jwks) load() <-chan struct{} {
+	if s.loading != nil {
+		return s.loading
+	}
+	// failed attempts count too, so an unreachable source is not hammered
+	s.attemptedAt = s.now()
+	loading := make(chan struct{})
+	s.loading = loading
+	go func() {
+		keys, err := s.fetchKeys()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			// keep verifying with the keys we have while the source is down
+			slog.Warn("JWKS could not be loaded, keeping the cached keys", "error", err, "cached_keys", len(s.keys))
+			s.err = err
+		} else {
+			s.keys, s.err = keys, nil
+		}
+		s.loading = nil
+		close(loading)
+	}()
+	return loading
+}
+
+func (s *jwks) fetchKeys() (map[string]verificationKey, error) {
+	data, err := s.fetch(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error loading JWKS: %w", err)
+	}
+	return parseJWKS(data)
+}
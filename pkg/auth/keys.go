@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySource looks up the key that verifies a token with key id kid, signed
+// with algorithm alg.
+type KeySource interface {
+	Key(ctx context.Context, kid string, alg string) (interface{}, error)
+}
+
+var errUnknownKey = errors.New("no key for the token")
+
+// staticKey verifies every token with the same key.
+type staticKey struct {
+	key interface{}
+}
+
+func (s staticKey) Key(_ context.Context, _ string, alg string) (interface{}, error) {
+	if !keyFits(s.key, alg) {
+		return nil, fmt.Errorf("%w: the key cannot verify %s", errUnknownKey, alg)
+	}
+	return s.key, nil
+}
+
+// NewPEMKeySource verifies tokens with the RSA or ECDSA public key, or the
+// public key of the certificate, in pemBytes.
+func NewPEMKeySource(pemBytes []byte) (KeySource, error) {
+	key, err := ParsePublicKeyPEM(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return staticKey{key: key}, nil
+}
+
+// NewHMACKeySource verifies HS256, HS384 and HS512 tokens with secret.
+func NewHMACKeySource(secret []byte) KeySource {
+	return staticKey{key: secret}
+}
+
+// ParsePublicKeyPEM parses the first PEM block of pemBytes as a PKIX or
+// PKCS #1 public key or as a certificate.
+func ParsePublicKeyPEM(pemBytes []byte) (interface{}, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// defaultAlgorithms are the algorithms accepted from keys when none are
+// configured: HS256 for an HMAC secret, DefaultAlgorithms otherwise.
+func defaultAlgorithms(keys KeySource) []string {
+	if key, ok := keys.(staticKey); ok {
+		if _, ok := key.key.([]byte); ok {
+			return []string{"HS256"}
+		}
+	}
+	return DefaultAlgorithms
+}
+
+// CheckAlgorithms reports an error when keys cannot verify any of
+// algorithms, or of the default ones when there are none, so every token
+// would be rejected. Key sets are only known once loaded and pass.
+func CheckAlgorithms(keys KeySource, algorithms []string) error {
+	key, ok := keys.(staticKey)
+	if !ok {
+		return nil
+	}
+	if len(algorithms) == 0 {
+		algorithms = defaultAlgorithms(keys)
+	}
+	for _, alg := range algorithms {
+		if keyFits(key.key, alg) {
+			return nil
+		}
+	}
+	return fmt.Errorf("an %s cannot verify any of the algorithms %s", keyKind(key.key), strings.Join(algorithms, ", "))
+}
+
+func keyKind(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return "RSA key"
+	case *ecdsa.PublicKey:
+		return "ECDSA key"
+	case []byte:
+		return "HMAC secret"
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// keyFits reports whether key is of the kind alg needs, so that a token can
+// never pick an algorithm its key was not meant for.
+func keyFits(key interface{}, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case []byte:
+		return strings.HasPrefix(alg, "HS")
+	}
+	return false
+}
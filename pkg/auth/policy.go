@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"log/slog"
+	"strings"
+)
+
+var ErrForbidden = apperror.Forbidden("forbidden", "the caller is not allowed to do this")
+
+// Permission names an operation a role may be allowed to perform, such as
+// "authors:read".
+type Permission string
+
+// Principal is the authenticated caller, whichever way it authenticated.
+// Roles holds the role names of a token or the scopes of an API key.
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated caller, if any.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Policy grants permissions to roles.
+type Policy struct {
+	grants map[string]map[Permission]bool
+}
+
+// NewPolicy builds a policy from the permissions granted to each role.
+func NewPolicy(roles map[string][]string) *Policy {
+	p := &Policy{grants: map[string]map[Permission]bool{}}
+	for role, permissions := range roles {
+		p.grants[strings.ToLower(role)] = map[Permission]bool{}
+		for _, permission := range permissions {
+			p.grants[strings.ToLower(role)][Permission(permission)] = true
+		}
+	}
+	return p
+}
+
+// Allows reports whether any of principal's roles grants permission.
+func (p *Policy) Allows(principal *Principal, permission Permission) bool {
+	for _, role := range principal.Roles {
+		if p.grants[strings.ToLower(role)][permission] {
+			return true
+		}
+	}
+	return false
+}
+
+// Defines reports whether role is one the policy grants permissions to.
+func (p *Policy) Defines(role string) bool {
+	_, ok := p.grants[strings.ToLower(role)]
+	return ok
+}
+
+// Require aborts requests whose principal lacks permission with 403, and
+// unauthenticated requests with 401. A nil policy, used when authentication
+// is disabled, allows everything.
+func (p *Policy) Require(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p == nil {
+			return
+		}
+		principal, ok := PrincipalFrom(c.Request.Context())
+		if !ok {
+			_ = c.Error(ErrMissingToken)
+			c.Abort()
+			return
+		}
+		if !p.Allows(principal, permission) {
+			slog.InfoContext(c.Request.Context(), "Denied permission", "permission", permission, "subject", principal.Subject, "roles", principal.Roles)
+			_ = c.Error(ErrForbidden)
+			c.Abort()
+		}
+	}
+}
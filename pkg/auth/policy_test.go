@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicy_Allows(t *testing.T) {
+	policy := NewPolicy(map[string][]string{
+		"reader": {"authors:read"},
+		"Editor": {"authors:read", "authors:write"},
+	})
+
+	require.True(t, policy.Allows(&Principal{Roles: []string{"reader"}}, "authors:read"))
+	require.False(t, policy.Allows(&Principal{Roles: []string{"reader"}}, "authors:write"))
+	require.True(t, policy.Allows(&Principal{Roles: []string{"unknown", "editor"}}, "authors:write"))
+	require.False(t, policy.Allows(&Principal{}, "authors:read"))
+}
+
+func TestClaims_Principal(t *testing.T) {
+	var claims Claims
+	require.NoError(t, json.Unmarshal([]byte(`{"sub":"alice","roles":"editor","scope":"reader admin"}`), &claims))
+
+	require.Equal(t, &Principal{Subject: "alice", Roles: []string{"editor", "reader", "admin"}}, claims.Principal())
+}
+
+func TestPolicy_Require(t *testing.T) {
+	policy := NewPolicy(map[string][]string{"reader": {"authors:read"}})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(Middleware(NewVerifier(NewHMACKeySource(secret), VerifierOptions{Algorithms: []string{"HS256"}}), nil))
+	router.GET("/authors", policy.Require("authors:read"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.DELETE("/authors", policy.Require("authors:delete"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	claims := Claims{RegisteredClaims: validClaims(), Roles: jwt.ClaimStrings{"reader"}}
+	token := sign(t, jwt.SigningMethodHS256, secret, "", claims)
+	send := func(method string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "/authors", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	require.Equal(t, http.StatusNoContent, send(http.MethodGet).Code)
+
+	rec := send(http.MethodDelete)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	var problem apperror.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, "forbidden", problem.Code)
+}
+
+func TestPolicy_RequireWithoutPolicy(t *testing.T) {
+	var policy *Policy
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/authors", policy.Require("authors:delete"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/authors", nil))
+
+	require.Equal(t, http.StatusNoContent, rec.Code)
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// authorColumns are the columns the author queries return: all of them but
+// bio_tsv, which only search reads. sqlc generates a row type with these
+// fields for each query, which Author converts back.
+type authorColumns struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (c authorColumns) author() Author {
+	return Author{ID: c.ID, Name: c.Name, Bio: c.Bio, Version: c.Version, UpdatedAt: c.UpdatedAt, DeletedAt: c.DeletedAt}
+}
+
+func (r CreateAuthorRow) Author() Author        { return authorColumns(r).author() }
+func (r GetAuthorRow) Author() Author           { return authorColumns(r).author() }
+func (r LockAuthorRow) Author() Author          { return authorColumns(r).author() }
+func (r UpdateAuthorRow) Author() Author        { return authorColumns(r).author() }
+func (r PartialUpdateAuthorRow) Author() Author { return authorColumns(r).author() }
+func (r DeleteAuthorRow) Author() Author        { return authorColumns(r).author() }
+func (r RestoreAuthorRow) Author() Author       { return authorColumns(r).author() }
+
+func (r CreateAuthorsRow) Author() Author {
+	return Author{ID: r.ID, Name: r.Name, Bio: r.Bio, Version: r.Version, UpdatedAt: r.UpdatedAt, DeletedAt: r.DeletedAt}
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"regexp"
+)
+
+// Wrapper decorates the DBTX queries run on, to observe them without
+// touching the generated code.
+type Wrapper func(DBTX) DBTX
+
+var queryName = regexp.MustCompile(`^-- name: (\w+) :\w+`)
+
+// QueryName returns the name of a query generated by sqlc, or of
+// ListAuthors, taken from its leading "-- name: Name :kind" comment. Other
+// statements have no name.
+func QueryName(query string) string {
+	if m := queryName.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Wrap returns db decorated by wrappers, the first innermost.
+func Wrap(db DBTX, wrappers ...Wrapper) DBTX {
+	for _, w := range wrappers {
+		db = w(db)
+	}
+	return db
+}
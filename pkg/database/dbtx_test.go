@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestQueryName(t *testing.T) {
+	require.Equal(t, "GetAuthor", QueryName(getAuthor))
+	require.Equal(t, "PurgeAuthor", QueryName(purgeAuthor))
+	require.Equal(t, "", QueryName("SAVEPOINT unit_of_work"))
+}
+
+type recordingDBTX struct {
+	DBTX
+	queries *[]string
+}
+
+func (r recordingDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	*r.queries = append(*r.queries, QueryName(query))
+	return r.DBTX.ExecContext(ctx, query, args...)
+}
+
+func TestTxManager_WrapsTransactions(t *testing.T) {
+	var queries []string
+	m := newCountingTxManager(t, &countingDriver{})
+	m = NewTxManager(m.db, func(db DBTX) DBTX { return recordingDBTX{DBTX: db, queries: &queries} })
+
+	require.NoError(t, m.InTx(context.Background(), func(q *Queries) error {
+		// the counting driver cannot run statements, only the call matters
+		_, _ = q.PurgeAuthor(context.Background(), 1)
+		return nil
+	}))
+
+	require.Equal(t, []string{"PurgeAuthor"}, queries)
+}
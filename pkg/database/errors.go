@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"github.com/lib/pq"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+)
+
+// SQLSTATE codes of the Postgres errors that are translated into domain
+// errors, see https://www.postgresql.org/docs/current/errcodes-appendix.html.
+const (
+	stringDataRightTruncation = "22001"
+	uniqueViolation           = "23505"
+	checkViolation            = "23514"
+	serializationFailure      = "40001"
+	deadlockDetected          = "40P01"
+)
+
+var (
+	ErrUniqueViolation = apperror.Conflict("unique_violation", "a record with the same unique value already exists")
+	ErrCheckViolation  = apperror.Unprocessable("check_violation", "a value does not satisfy a constraint")
+	ErrValueTooLong    = apperror.Unprocessable("value_too_long", "a value is too long")
+	ErrRetryable       = apperror.Retryable("transaction_conflict", "the request conflicted with a concurrent one, please retry")
+)
+
+// TranslateError maps a Postgres constraint violation or transaction conflict
+// in err onto the domain error it stands for, keeping err as the cause. Any
+// other error is returned unchanged.
+func TranslateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	var appErr *apperror.Error
+	switch pqErr.Code {
+	case uniqueViolation:
+		appErr = ErrUniqueViolation.WithCause(err)
+		if pqErr.Constraint != "" {
+			appErr.Fields = []apperror.FieldError{{Field: pqErr.Constraint, Code: "unique", Message: "must be unique"}}
+		}
+	case checkViolation:
+		appErr = ErrCheckViolation.WithCause(err)
+		if pqErr.Constraint != "" {
+			appErr.Fields = []apperror.FieldError{{Field: pqErr.Constraint, Code: "check", Message: "does not satisfy the constraint"}}
+		}
+	case stringDataRightTruncation:
+		appErr = ErrValueTooLong.WithCause(err)
+		if pqErr.Column != "" {
+			appErr.Fields = []apperror.FieldError{{Field: pqErr.Column, Code: "max", Message: "is too long"}}
+		}
+	case serializationFailure, deadlockDetected:
+		appErr = ErrRetryable.WithCause(err)
+	default:
+		return err
+	}
+	return appErr
+}
+
+// IsRetryable reports whether err is a transient conflict with another
+// transaction, after which the whole transaction may simply be run again.
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case serializationFailure, deadlockDetected:
+		return true
+	}
+	return false
+}
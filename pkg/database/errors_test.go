@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/potatowhite/restfulapi/cmd/config"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		code   pq.ErrorCode
+		status int
+	}{
+		{uniqueViolation, http.StatusConflict},
+		{checkViolation, http.StatusUnprocessableEntity},
+		{stringDataRightTruncation, http.StatusUnprocessableEntity},
+		{serializationFailure, http.StatusServiceUnavailable},
+		{deadlockDetected, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		cause := fmt.Errorf("error creating author: %w", &pq.Error{Code: tt.code})
+
+		err := TranslateError(cause)
+
+		require.Equal(t, tt.status, apperror.Status(err), tt.code)
+		require.ErrorIs(t, err, cause, tt.code)
+	}
+}
+
+func TestTranslateError_KeepsOtherErrors(t *testing.T) {
+	foreignKey := &pq.Error{Code: "23503"}
+	require.Same(t, foreignKey, TranslateError(foreignKey))
+	require.Same(t, sql.ErrNoRows, TranslateError(sql.ErrNoRows))
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, IsRetryable(fmt.Errorf("commit: %w", &pq.Error{Code: serializationFailure})))
+	require.True(t, IsRetryable(&pq.Error{Code: deadlockDetected}))
+	require.False(t, IsRetryable(&pq.Error{Code: uniqueViolation}))
+	require.False(t, IsRetryable(errors.New("connection reset")))
+}
+
+// PostgresErrorSuite provokes the translated errors on a real Postgres, so
+// the codes and fields lib/pq reports are checked rather than assumed.
+type PostgresErrorSuite struct {
+	suite.Suite
+	db *sql.DB
+}
+
+func TestPostgresErrorSuite(t *testing.T) {
+	suite.Run(t, new(PostgresErrorSuite))
+}
+
+func (s *PostgresErrorSuite) SetupSuite() {
+	cfg, err := config.Read()
+	s.Require().NoError(err)
+
+	postgres, err := NewPostgres(Options{URL: string(cfg.Database.URL), Host: cfg.Database.Host, Port: cfg.Database.Port, User: cfg.Database.Username, Password: string(cfg.Database.Password), Dbname: cfg.Database.Dbname, SSLMode: cfg.Database.SSLMode})
+	s.Require().NoError(err)
+	s.db = postgres.DB
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS translate_error_test
+(
+    id    BIGINT PRIMARY KEY,
+    code  VARCHAR(4) NOT NULL CONSTRAINT translate_error_test_code_key UNIQUE,
+    count INT        NOT NULL CONSTRAINT translate_error_test_count_check CHECK (count >= 0)
+)`)
+	s.Require().NoError(err)
+}
+
+func (s *PostgresErrorSuite) TearDownSuite() {
+	_, err := s.db.Exec("DROP TABLE translate_error_test")
+	s.Require().NoError(err)
+	s.Require().NoError(s.db.Close())
+}
+
+func (s *PostgresErrorSuite) SetupTest() {
+	_, err := s.db.Exec("TRUNCATE translate_error_test")
+	s.Require().NoError(err)
+	_, err = s.db.Exec("INSERT INTO translate_error_test (id, code, count) VALUES (1, 'a', 0)")
+	s.Require().NoError(err)
+}
+
+func (s *PostgresErrorSuite) TestUniqueViolation() {
+	_, err := s.db.Exec("INSERT INTO translate_error_test (id, code, count) VALUES (2, 'a', 0)")
+
+	appErr := apperror.As(TranslateError(err))
+	s.Require().Equal(apperror.KindConflict, appErr.Kind)
+	s.Require().Equal("unique_violation", appErr.Code)
+	s.Require().Equal("translate_error_test_code_key", appErr.Fields[0].Field)
+}
+
+func (s *PostgresErrorSuite) TestCheckViolation() {
+	_, err := s.db.Exec("UPDATE translate_error_test SET count = -1 WHERE id = 1")
+
+	appErr := apperror.As(TranslateError(err))
+	s.Require().Equal(apperror.KindUnprocessable, appErr.Kind)
+	s.Require().Equal("check_violation", appErr.Code)
+	s.Require().Equal("translate_error_test_count_check", appErr.Fields[0].Field)
+}
+
+func (s *PostgresErrorSuite) TestValueTooLong() {
+	_, err := s.db.Exec("INSERT INTO translate_error_test (id, code, count) VALUES (2, $1, 0)", strings.Repeat("b", 5))
+
+	appErr := apperror.As(TranslateError(err))
+	s.Require().Equal(apperror.KindUnprocessable, appErr.Kind)
+	s.Require().Equal("value_too_long", appErr.Code)
+}
+
+func (s *PostgresErrorSuite) TestSerializationFailure() {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	s.Require().NoError(err)
+	defer tx.Rollback()
+
+	// take the snapshot, then change the row behind the transaction's back
+	var count int
+	s.Require().NoError(tx.QueryRowContext(ctx, "SELECT count FROM translate_error_test WHERE id = 1").Scan(&count))
+	_, err = s.db.ExecContext(ctx, "UPDATE translate_error_test SET count = count + 1 WHERE id = 1")
+	s.Require().NoError(err)
+
+	_, err = tx.ExecContext(ctx, "UPDATE translate_error_test SET count = count + 1 WHERE id = 1")
+
+	s.Require().True(IsRetryable(err))
+	appErr := apperror.As(TranslateError(err))
+	s.Require().Equal(apperror.KindRetryable, appErr.Kind)
+	s.Require().Equal("transaction_conflict", appErr.Code)
+}
+
+func (s *PostgresErrorSuite) TestDeadlock() {
+	ctx := context.Background()
+	_, err := s.db.ExecContext(ctx, "INSERT INTO translate_error_test (id, code, count) VALUES (2, 'b', 0)")
+	s.Require().NoError(err)
+
+	first, err := s.db.BeginTx(ctx, nil)
+	s.Require().NoError(err)
+	defer first.Rollback()
+	second, err := s.db.BeginTx(ctx, nil)
+	s.Require().NoError(err)
+	defer second.Rollback()
+
+	// each transaction locks one row, then waits for the other's
+	_, err = first.ExecContext(ctx, "UPDATE translate_error_test SET count = 1 WHERE id = 1")
+	s.Require().NoError(err)
+	_, err = second.ExecContext(ctx, "UPDATE translate_error_test SET count = 1 WHERE id = 2")
+	s.Require().NoError(err)
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := first.ExecContext(ctx, "UPDATE translate_error_test SET count = 2 WHERE id = 2")
+		if err != nil {
+			_ = first.Rollback()
+		}
+		errs <- err
+	}()
+	go func() {
+		_, err := second.ExecContext(ctx, "UPDATE translate_error_test SET count = 2 WHERE id = 1")
+		if err != nil {
+			_ = second.Rollback()
+		}
+		errs <- err
+	}()
+
+	// Postgres aborts one of them, which lets the other one go on
+	var deadlock error
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			deadlock = err
+		}
+	}
+	s.Require().Error(deadlock)
+	s.Require().True(IsRetryable(deadlock))
+	s.Require().Equal(apperror.KindRetryable, apperror.As(TranslateError(deadlock)).Kind)
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// AuthorSortColumn is a column ListAuthors is allowed to order by.
+type AuthorSortColumn string
+
+const (
+	AuthorSortName AuthorSortColumn = "name"
+	AuthorSortID   AuthorSortColumn = "id"
+)
+
+// authorSortColumns whitelists the SQL emitted for each sort column, so
+// nothing supplied by a caller ever ends up in the query text.
+var authorSortColumns = map[AuthorSortColumn]string{
+	AuthorSortName: "name",
+	AuthorSortID:   "id",
+}
+
+type AuthorSort struct {
+	Column AuthorSortColumn
+	Desc   bool
+}
+
+type ListAuthorsParams struct {
+	Name        string
+	NamePrefix  string
+	BioContains string
+	// Sort must end with a unique column (id) for keyset pagination to be
+	// stable. An empty Sort orders by name, id.
+	Sort []AuthorSort
+	// After is the last author of the previous page, nil for the first page.
+	After *Author
+	Limit int32
+}
+
+func (q *Queries) ListAuthors(ctx context.Context, arg ListAuthorsParams) ([]Author, error) {
+	query, args, err := buildListAuthors(arg)
+	if err != nil {
+		return nil, err
+	}
+	// named like the generated queries, for wrappers to tell it apart
+	rows, err := q.db.QueryContext(ctx, "-- name: ListAuthors :many\n"+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Author
+	for rows.Next() {
+		var i Author
+		if err := rows.Scan(&i.ID, &i.Name, &i.Bio, &i.Version, &i.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func buildListAuthors(arg ListAuthorsParams) (string, []interface{}, error) {
+	sorts := arg.Sort
+	if len(sorts) == 0 {
+		sorts = []AuthorSort{{Column: AuthorSortName}, {Column: AuthorSortID}}
+	}
+	for _, s := range sorts {
+		if _, ok := authorSortColumns[s.Column]; !ok {
+			return "", nil, fmt.Errorf("unsupported sort column %q", s.Column)
+		}
+	}
+
+	var (
+		where = []string{"deleted_at IS NULL"}
+		args  []interface{}
+	)
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if arg.Name != "" {
+		where = append(where, "name = "+bind(arg.Name))
+	}
+	if arg.NamePrefix != "" {
+		where = append(where, "name LIKE "+bind(escapeLike(arg.NamePrefix)+"%"))
+	}
+	if arg.BioContains != "" {
+		where = append(where, "bio ILIKE "+bind("%"+escapeLike(arg.BioContains)+"%"))
+	}
+	if arg.After != nil {
+		where = append(where, keysetCondition(sorts, arg.After, bind))
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT id, name, bio, version, updated_at\nFROM authors\n")
+	b.WriteString("WHERE " + strings.Join(where, "\n  AND ") + "\n")
+	order := make([]string, len(sorts))
+	for i, s := range sorts {
+		order[i] = authorSortColumns[s.Column]
+		if s.Desc {
+			order[i] += " DESC"
+		}
+	}
+	b.WriteString("ORDER BY " + strings.Join(order, ", ") + "\n")
+	b.WriteString("LIMIT " + bind(arg.Limit))
+
+	return b.String(), args, nil
+}
+
+// keysetCondition selects the rows that come after the given author in the
+// sort order. Mixed directions rule out a single row comparison, so it is
+// expanded to (a > x) OR (a = x AND b > y) OR ...
+func keysetCondition(sorts []AuthorSort, after *Author, bind func(interface{}) string) string {
+	var (
+		terms []string
+		equal []string
+	)
+	for _, s := range sorts {
+		column := authorSortColumns[s.Column]
+		value := bind(authorSortValue(after, s.Column))
+		op := ">"
+		if s.Desc {
+			op = "<"
+		}
+		term := append(append([]string{}, equal...), column+" "+op+" "+value)
+		terms = append(terms, "("+strings.Join(term, " AND ")+")")
+		equal = append(equal, column+" = "+value)
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+func authorSortValue(a *Author, column AuthorSortColumn) interface{} {
+	if column == AuthorSortName {
+		return a.Name
+	}
+	return a.ID
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
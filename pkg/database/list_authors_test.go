@@ -0,0 +1,45 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuildListAuthors_Defaults(t *testing.T) {
+	query, args, err := buildListAuthors(ListAuthorsParams{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, "SELECT id, name, bio, version, updated_at\nFROM authors\nWHERE deleted_at IS NULL\nORDER BY name, id\nLIMIT $1", query)
+	require.Equal(t, []interface{}{int32(10)}, args)
+}
+
+func TestBuildListAuthors_FiltersAreParameterized(t *testing.T) {
+	query, args, err := buildListAuthors(ListAuthorsParams{
+		Name:        "x'; DROP TABLE authors; --",
+		NamePrefix:  "50%_",
+		BioContains: `a\b`,
+		Limit:       5,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "SELECT id, name, bio, version, updated_at\nFROM authors\n"+
+		"WHERE deleted_at IS NULL\n  AND name = $1\n  AND name LIKE $2\n  AND bio ILIKE $3\n"+
+		"ORDER BY name, id\nLIMIT $4", query)
+	require.Equal(t, []interface{}{"x'; DROP TABLE authors; --", `50\%\_%`, `%a\\b%`, int32(5)}, args)
+}
+
+func TestBuildListAuthors_KeysetWithMixedDirections(t *testing.T) {
+	query, args, err := buildListAuthors(ListAuthorsParams{
+		Sort:  []AuthorSort{{Column: AuthorSortName, Desc: true}, {Column: AuthorSortID}},
+		After: &Author{ID: 7, Name: "m"},
+		Limit: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "SELECT id, name, bio, version, updated_at\nFROM authors\n"+
+		"WHERE deleted_at IS NULL\n  AND ((name < $1) OR (name = $1 AND id > $2))\n"+
+		"ORDER BY name DESC, id\nLIMIT $3", query)
+	require.Equal(t, []interface{}{"m", int64(7), int32(2)}, args)
+}
+
+func TestBuildListAuthors_RejectsUnknownColumn(t *testing.T) {
+	_, _, err := buildListAuthors(ListAuthorsParams{Sort: []AuthorSort{{Column: "bio; --"}}})
+	require.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		require.Equal(t, int64(i+1), m.Version, "migration versions must be contiguous")
+		require.NotEmpty(t, m.Down, "migration %d_%s has no down file", m.Version, m.Name)
+	}
+}
+
+func TestLoadMigrations_Ordering(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"migrations/0010_second.up.sql":  {Data: []byte("SELECT 2")},
+		"migrations/0002_first.up.sql":   {Data: []byte("SELECT 1")},
+		"migrations/0002_first.down.sql": {Data: []byte("SELECT -1")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []migration{
+		{Version: 2, Name: "first", Up: "SELECT 1", Down: "SELECT -1"},
+		{Version: 10, Name: "second", Up: "SELECT 2"},
+	}, migrations)
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":   {"migrations/schema.sql": {}},
+		"missing up": {"migrations/0001_init.down.sql": {}},
+		"conflicting name": {
+			"migrations/0001_init.up.sql":  {Data: []byte("SELECT 1")},
+			"migrations/0001_other.up.sql": {Data: []byte("SELECT 1")},
+		},
+	} {
+		_, err := loadMigrations(fsys)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,8 @@
+-- IF NOT EXISTS adopts databases that were initialised from the old
+-- docker-entrypoint schema.sql.
+CREATE TABLE IF NOT EXISTS authors
+(
+    id   BIGSERIAL PRIMARY KEY,
+    name VARCHAR(32) NOT NULL,
+    bio  TEXT        NOT NULL
+);
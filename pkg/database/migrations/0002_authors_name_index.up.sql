@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS authors_name_id_idx ON authors (name, id);
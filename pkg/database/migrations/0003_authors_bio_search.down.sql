@@ -0,0 +1,4 @@
+DROP INDEX authors_bio_tsv_idx;
+
+ALTER TABLE authors
+    DROP COLUMN bio_tsv;
@@ -0,0 +1,4 @@
+ALTER TABLE authors
+    ADD COLUMN IF NOT EXISTS bio_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', bio)) STORED;
+
+CREATE INDEX IF NOT EXISTS authors_bio_tsv_idx ON authors USING GIN (bio_tsv);
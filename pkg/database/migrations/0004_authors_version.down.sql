@@ -0,0 +1,2 @@
+ALTER TABLE authors
+    DROP COLUMN version;
@@ -0,0 +1,2 @@
+ALTER TABLE authors
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
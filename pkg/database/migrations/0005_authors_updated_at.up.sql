@@ -0,0 +1,2 @@
+ALTER TABLE authors
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,6 @@
+DELETE
+FROM authors
+WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE authors
+    DROP COLUMN deleted_at;
@@ -0,0 +1,13 @@
+-- No foreign key: the history of a purged author is kept.
+CREATE TABLE author_audit
+(
+    id         BIGSERIAL PRIMARY KEY,
+    author_id  BIGINT      NOT NULL,
+    action     TEXT        NOT NULL,
+    actor      TEXT        NOT NULL,
+    before     JSONB       NOT NULL,
+    after      JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX author_audit_author_id_idx ON author_audit (author_id, id);
@@ -0,0 +1,14 @@
+-- Only a SHA-256 hash of each key is stored; the key itself is shown once,
+-- when it is created. prefix identifies a key in listings.
+CREATE TABLE api_keys
+(
+    id           BIGSERIAL PRIMARY KEY,
+    prefix       TEXT        NOT NULL,
+    key_hash     BYTEA       NOT NULL UNIQUE,
+    owner        TEXT        NOT NULL,
+    scopes       TEXT[]      NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at   TIMESTAMPTZ
+);
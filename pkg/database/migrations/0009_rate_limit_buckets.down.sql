@@ -0,0 +1,2 @@
+DROP FUNCTION rate_limit_refill;
+DROP TABLE rate_limit_buckets;
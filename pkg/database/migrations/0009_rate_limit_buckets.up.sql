@@ -0,0 +1,24 @@
+-- Token buckets of the rate limiter, shared by every replica. allowed is
+-- the outcome of the last request taken from the bucket.
+CREATE TABLE rate_limit_buckets
+(
+    key        TEXT PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    allowed    BOOLEAN          NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_updated_at_idx ON rate_limit_buckets (updated_at);
+
+-- rate_limit_refill is how many tokens a bucket that held tokens at
+-- updated_at holds now, when it is refilled with rate tokens a second up to
+-- burst.
+CREATE FUNCTION rate_limit_refill(tokens DOUBLE PRECISION, updated_at TIMESTAMPTZ,
+                                  rate DOUBLE PRECISION, burst DOUBLE PRECISION)
+    RETURNS DOUBLE PRECISION
+    LANGUAGE sql
+    STABLE
+AS
+$$
+SELECT LEAST(burst, tokens + EXTRACT(EPOCH FROM now() - updated_at)::DOUBLE PRECISION * rate)
+$$;
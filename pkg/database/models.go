@@ -4,10 +4,47 @@
 
 package database
 
-import ()
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type ApiKey struct {
+	ID         int64
+	Prefix     string
+	KeyHash    []byte
+	Owner      string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+	RevokedAt  sql.NullTime
+}
 
 type Author struct {
-	ID   int64
-	Name string
-	Bio  string
+	ID        int64
+	Name      string
+	Bio       string
+	BioTsv    interface{}
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+type AuthorAudit struct {
+	ID        int64
+	AuthorID  int64
+	Action    string
+	Actor     string
+	Before    json.RawMessage
+	After     json.RawMessage
+	CreatedAt time.Time
+}
+
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	Allowed   bool
+	UpdatedAt time.Time
 }
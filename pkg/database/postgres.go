@@ -2,29 +2,254 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq"
-	"log"
+	"github.com/lib/pq"
+	"log/slog"
+	"math"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var (
-	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
-)
+// ErrInvalidOptions is wrapped by the errors describing invalid Options.
+var ErrInvalidOptions = errors.New("invalid database options")
+
+// sslModes are the SSL modes lib/pq implements.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// Options configures the connection to Postgres and its pool. Zero values
+// leave the defaults of lib/pq and database/sql in place.
+type Options struct {
+	// URL is a complete connection string, a postgres:// URL or libpq
+	// key=value pairs. Host, Port, User, Password and Dbname are ignored
+	// when it is set, and the settings it holds win over the ones below.
+	URL      string
+	Host     string
+	Port     uint
+	User     string
+	Password string
+	Dbname   string
+	// SSLMode is disable, require, verify-ca or verify-full; lib/pq
+	// requires SSL when it is empty. SSLRootCert verifies the server, while
+	// SSLCert and SSLKey authenticate the client.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// ConnectTimeout bounds establishing a connection, in whole seconds.
+	ConnectTimeout time.Duration
+	// StatementTimeout aborts statements running longer, in milliseconds.
+	StatementTimeout time.Duration
+	// ApplicationName identifies the service in pg_stat_activity.
+	ApplicationName string
+	// MaxOpenConns and MaxIdleConns limit the open and idle connections of
+	// the pool, ConnMaxLifetime and ConnMaxIdleTime how long they are
+	// reused; see sql.DB.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Validate reports every invalid setting in one error wrapping
+// ErrInvalidOptions.
+func (o Options) Validate() error {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if o.URL != "" {
+		if _, err := pq.NewConnector(o.URL); err != nil {
+			invalid("url cannot be parsed: %s", err)
+		}
+	} else {
+		if o.Host == "" {
+			invalid("host is required")
+		}
+		if o.Port == 0 || o.Port > math.MaxUint16 {
+			invalid("port %d is not a TCP port", o.Port)
+		}
+		if o.User == "" {
+			invalid("user is required")
+		}
+		if o.Dbname == "" {
+			invalid("dbname is required")
+		}
+	}
+
+	if o.SSLMode != "" && !contains(sslModes, o.SSLMode) {
+		invalid("sslmode %q is not one of %s", o.SSLMode, strings.Join(sslModes, ", "))
+	}
+	if o.SSLMode == "disable" && (o.SSLRootCert != "" || o.SSLCert != "" || o.SSLKey != "") {
+		invalid("sslrootcert, sslcert and sslkey have no effect with sslmode disable")
+	}
+	if (o.SSLCert == "") != (o.SSLKey == "") {
+		invalid("sslcert and sslkey have to be set together")
+	}
+	for name, path := range map[string]string{"sslrootcert": o.SSLRootCert, "sslcert": o.SSLCert, "sslkey": o.SSLKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			invalid("%s cannot be read: %s", name, err)
+		}
+	}
+
+	if o.ConnectTimeout < 0 {
+		invalid("connect_timeout %s is negative", o.ConnectTimeout)
+	}
+	if o.StatementTimeout < 0 {
+		invalid("statement_timeout %s is negative", o.StatementTimeout)
+	} else if o.StatementTimeout > 0 && o.StatementTimeout < time.Millisecond {
+		invalid("statement_timeout %s is below a millisecond", o.StatementTimeout)
+	}
+
+	if o.MaxOpenConns < 0 {
+		invalid("max_open_conns %d is negative", o.MaxOpenConns)
+	}
+	if o.MaxIdleConns < 0 {
+		invalid("max_idle_conns %d is negative", o.MaxIdleConns)
+	}
+	if o.MaxOpenConns > 0 && o.MaxIdleConns > o.MaxOpenConns {
+		invalid("max_idle_conns %d exceeds max_open_conns %d", o.MaxIdleConns, o.MaxOpenConns)
+	}
+	if o.ConnMaxLifetime < 0 {
+		invalid("conn_max_lifetime %s is negative", o.ConnMaxLifetime)
+	}
+	if o.ConnMaxIdleTime < 0 {
+		invalid("conn_max_idle_time %s is negative", o.ConnMaxIdleTime)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DSN returns the libpq connection string of o. Settings appearing twice
+// take the last value, so the ones of the URL come last.
+func (o Options) DSN() (string, error) {
+	var pairs []string
+	set := func(key string, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+quote(value))
+		}
+	}
+
+	if o.URL == "" {
+		set("host", o.Host)
+		set("port", strconv.FormatUint(uint64(o.Port), 10))
+		set("user", o.User)
+		set("password", o.Password)
+		set("dbname", o.Dbname)
+	}
+	set("sslmode", o.SSLMode)
+	set("sslrootcert", o.SSLRootCert)
+	set("sslcert", o.SSLCert)
+	set("sslkey", o.SSLKey)
+	if o.ConnectTimeout > 0 {
+		set("connect_timeout", strconv.FormatInt(int64(math.Ceil(o.ConnectTimeout.Seconds())), 10))
+	}
+	if o.StatementTimeout > 0 {
+		// lib/pq sends settings it does not know as run-time parameters
+		set("statement_timeout", strconv.FormatInt(o.StatementTimeout.Milliseconds(), 10))
+	}
+	set("application_name", o.ApplicationName)
+
+	if o.URL != "" {
+		dsn := o.URL
+		if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+			var err error
+			if dsn, err = pq.ParseURL(dsn); err != nil {
+				return "", err
+			}
+		}
+		pairs = append(pairs, dsn)
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// LogValue describes the server o connects to, leaving out the password.
+func (o Options) LogValue() slog.Value {
+	if o.URL != "" {
+		if u, err := url.Parse(o.URL); err == nil && u.Scheme != "" {
+			return slog.GroupValue(slog.String("url", u.Redacted()))
+		}
+		// key=value pairs cannot be logged without parsing out the password
+		return slog.GroupValue(slog.String("url", "[REDACTED]"))
+	}
+	return slog.GroupValue(
+		slog.String("host", o.Host),
+		slog.Uint64("port", uint64(o.Port)),
+		slog.String("user", o.User),
+		slog.String("dbname", o.Dbname),
+		slog.String("sslmode", o.SSLMode),
+	)
+}
+
+// quote quotes value for a libpq connection string.
+func quote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
 
 type Postgres struct {
 	DB *sql.DB
 }
 
-func NewPostgres(host string, port uint, user string, password string, dbname string) (*Postgres, error) {
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	logger.Printf("Connecting to database: %s", dsn)
-	db, err := sql.Open("postgres", dsn)
+// NewPostgres validates opts, opens a pool configured by them and checks
+// that the database answers.
+func NewPostgres(opts Options) (*Postgres, error) {
+	p, err := OpenPostgres(opts)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	if err := p.DB.Ping(); err != nil {
+		_ = p.DB.Close()
 		return nil, err
 	}
+	return p, nil
+}
+
+// OpenPostgres validates opts and opens a pool configured by them without
+// connecting yet, for databases that may be down at startup.
+func OpenPostgres(opts Options) (*Postgres, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	dsn, err := opts.DSN()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Opening database connection pool", "database", opts)
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	// zero would disable idle connections rather than keep the default
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
 	return &Postgres{DB: db}, nil
 }
+
+// Close closes the connection pool.
+func (p *Postgres) Close() error {
+	return p.DB.Close()
+}
@@ -7,12 +7,54 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+const createApiKey = `-- name: CreateApiKey :one
+INSERT INTO api_keys (prefix, key_hash, owner, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+    RETURNING id, prefix, key_hash, owner, scopes, expires_at, last_used_at, created_at, revoked_at
+`
+
+type CreateApiKeyParams struct {
+	Prefix    string
+	KeyHash   []byte
+	Owner     string
+	Scopes    []string
+	ExpiresAt sql.NullTime
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, createApiKey,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Owner,
+		pq.Array(arg.Scopes),
+		arg.ExpiresAt,
+	)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Owner,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const createAuthor = `-- name: CreateAuthor :one
 INSERT INTO authors (name, bio)
 VALUES ($1, $2)
-    RETURNING id, name, bio
+    RETURNING id, name, bio, version, updated_at, deleted_at
 `
 
 type CreateAuthorParams struct {
@@ -20,54 +62,288 @@ type CreateAuthorParams struct {
 	Bio  string
 }
 
-func (q *Queries) CreateAuthor(ctx context.Context, arg CreateAuthorParams) (Author, error) {
+type CreateAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) CreateAuthor(ctx context.Context, arg CreateAuthorParams) (CreateAuthorRow, error) {
 	row := q.db.QueryRowContext(ctx, createAuthor, arg.Name, arg.Bio)
-	var i Author
-	err := row.Scan(&i.ID, &i.Name, &i.Bio)
+	var i CreateAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
 	return i, err
 }
 
-const deleteAuthor = `-- name: DeleteAuthor :exec
+const createAuthorAudit = `-- name: CreateAuthorAudit :exec
+INSERT INTO author_audit (author_id, action, actor, before, after)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateAuthorAuditParams struct {
+	AuthorID int64
+	Action   string
+	Actor    string
+	Before   json.RawMessage
+	After    json.RawMessage
+}
+
+func (q *Queries) CreateAuthorAudit(ctx context.Context, arg CreateAuthorAuditParams) error {
+	_, err := q.db.ExecContext(ctx, createAuthorAudit,
+		arg.AuthorID,
+		arg.Action,
+		arg.Actor,
+		arg.Before,
+		arg.After,
+	)
+	return err
+}
+
+const createAuthors = `-- name: CreateAuthors :many
+WITH input AS MATERIALIZED (SELECT nextval(pg_get_serial_sequence('authors', 'id')) AS id, name, bio, ordinality
+                            FROM unnest($1::VARCHAR(32)[], $2::TEXT[]) WITH ORDINALITY AS i (name, bio, ordinality)),
+     created AS (
+         INSERT INTO authors (id, name, bio)
+             SELECT id, name, bio FROM input
+             RETURNING id, name, bio, version, updated_at, deleted_at)
+SELECT created.id, created.name, created.bio, created.version, created.updated_at, created.deleted_at, input.ordinality
+FROM created
+         JOIN input USING (id)
+ORDER BY input.ordinality
+`
+
+type CreateAuthorsParams struct {
+	Names []string
+	Bios  []string
+}
+
+type CreateAuthorsRow struct {
+	ID         int64
+	Name       string
+	Bio        string
+	Version    int64
+	UpdatedAt  time.Time
+	DeletedAt  sql.NullTime
+	Ordinality int64
+}
+
+// Ids are drawn up front so every created row can be joined back to the
+// position of its input: RETURNING order is not guaranteed.
+func (q *Queries) CreateAuthors(ctx context.Context, arg CreateAuthorsParams) ([]CreateAuthorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, createAuthors, pq.Array(arg.Names), pq.Array(arg.Bios))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CreateAuthorsRow
+	for rows.Next() {
+		var i CreateAuthorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Bio,
+			&i.Version,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Ordinality,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteIdleRateLimitBuckets = `-- name: DeleteIdleRateLimitBuckets :execrows
 DELETE
-FROM authors
+FROM rate_limit_buckets
+WHERE updated_at < $1
+`
+
+func (q *Queries) DeleteIdleRateLimitBuckets(ctx context.Context, updatedAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteIdleRateLimitBuckets, updatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteAuthor = `-- name: DeleteAuthor :one
+UPDATE authors
+SET deleted_at = now(),
+    version    = version + 1,
+    updated_at = now()
 WHERE id = $1
+  AND version = $2
+  AND deleted_at IS NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at
 `
 
-func (q *Queries) DeleteAuthor(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, deleteAuthor, id)
-	return err
+type DeleteAuthorParams struct {
+	ID      int64
+	Version int64
+}
+
+type DeleteAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) DeleteAuthor(ctx context.Context, arg DeleteAuthorParams) (DeleteAuthorRow, error) {
+	row := q.db.QueryRowContext(ctx, deleteAuthor, arg.ID, arg.Version)
+	var i DeleteAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getApiKeyByHash = `-- name: GetApiKeyByHash :one
+SELECT id, prefix, key_hash, owner, scopes, expires_at, last_used_at, created_at, revoked_at
+FROM api_keys
+WHERE key_hash = $1
+  AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > now())
+    LIMIT 1
+`
+
+func (q *Queries) GetApiKeyByHash(ctx context.Context, keyHash []byte) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, getApiKeyByHash, keyHash)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Owner,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
 }
 
 const getAuthor = `-- name: GetAuthor :one
-SELECT id, name, bio
+SELECT id, name, bio, version, updated_at, deleted_at
 FROM authors
 WHERE id = $1
+  AND deleted_at IS NULL
     LIMIT 1
 `
 
-func (q *Queries) GetAuthor(ctx context.Context, id int64) (Author, error) {
+type GetAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) GetAuthor(ctx context.Context, id int64) (GetAuthorRow, error) {
 	row := q.db.QueryRowContext(ctx, getAuthor, id)
-	var i Author
-	err := row.Scan(&i.ID, &i.Name, &i.Bio)
+	var i GetAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
 	return i, err
 }
 
-const listAuthors = `-- name: ListAuthors :many
-SELECT id, name, bio
-FROM authors
-ORDER BY name
+const listApiKeys = `-- name: ListApiKeys :many
+SELECT id, prefix, key_hash, owner, scopes, expires_at, last_used_at, created_at, revoked_at
+FROM api_keys
+ORDER BY id
+`
+
+func (q *Queries) ListApiKeys(ctx context.Context) ([]ApiKey, error) {
+	rows, err := q.db.QueryContext(ctx, listApiKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApiKey
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Owner,
+			pq.Array(&i.Scopes),
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuthorAudit = `-- name: ListAuthorAudit :many
+SELECT id, author_id, action, actor, before, after, created_at
+FROM author_audit
+WHERE author_id = $1
+ORDER BY id
 `
 
-func (q *Queries) ListAuthors(ctx context.Context) ([]Author, error) {
-	rows, err := q.db.QueryContext(ctx, listAuthors)
+func (q *Queries) ListAuthorAudit(ctx context.Context, authorID int64) ([]AuthorAudit, error) {
+	rows, err := q.db.QueryContext(ctx, listAuthorAudit, authorID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Author
+	var items []AuthorAudit
 	for rows.Next() {
-		var i Author
-		if err := rows.Scan(&i.ID, &i.Name, &i.Bio); err != nil {
+		var i AuthorAudit
+		if err := rows.Scan(
+			&i.ID,
+			&i.AuthorID,
+			&i.Action,
+			&i.Actor,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -81,12 +357,46 @@ func (q *Queries) ListAuthors(ctx context.Context) ([]Author, error) {
 	return items, nil
 }
 
+const lockAuthor = `-- name: LockAuthor :one
+SELECT id, name, bio, version, updated_at, deleted_at
+FROM authors
+WHERE id = $1
+    FOR UPDATE
+`
+
+type LockAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) LockAuthor(ctx context.Context, id int64) (LockAuthorRow, error) {
+	row := q.db.QueryRowContext(ctx, lockAuthor, id)
+	var i LockAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const partialUpdateAuthor = `-- name: PartialUpdateAuthor :one
 UPDATE authors
-SET name = CASE WHEN $1::boolean THEN $2::VARCHAR(32) ELSE name END,
-    bio  = CASE WHEN $3::boolean THEN $4::TEXT ELSE bio END
+SET name       = CASE WHEN $1::boolean THEN $2::VARCHAR(32) ELSE name END,
+    bio        = CASE WHEN $3::boolean THEN $4::TEXT ELSE bio END,
+    version    = version + 1,
+    updated_at = now()
 WHERE id = $5
-RETURNING id, name, bio
+  AND version = $6
+  AND deleted_at IS NULL
+RETURNING id, name, bio, version, updated_at, deleted_at
 `
 
 type PartialUpdateAuthorParams struct {
@@ -95,21 +405,213 @@ type PartialUpdateAuthorParams struct {
 	UpdateBio  bool
 	Bio        string
 	ID         int64
+	Version    int64
+}
+
+type PartialUpdateAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
 }
 
-func (q *Queries) PartialUpdateAuthor(ctx context.Context, arg PartialUpdateAuthorParams) (Author, error) {
+func (q *Queries) PartialUpdateAuthor(ctx context.Context, arg PartialUpdateAuthorParams) (PartialUpdateAuthorRow, error) {
 	row := q.db.QueryRowContext(ctx, partialUpdateAuthor,
 		arg.UpdateName,
 		arg.Name,
 		arg.UpdateBio,
 		arg.Bio,
 		arg.ID,
+		arg.Version,
+	)
+	var i PartialUpdateAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const purgeAuthor = `-- name: PurgeAuthor :execrows
+DELETE
+FROM authors
+WHERE id = $1
+`
+
+func (q *Queries) PurgeAuthor(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeAuthor, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreAuthor = `-- name: RestoreAuthor :one
+UPDATE authors
+SET deleted_at = NULL,
+    version    = version + 1,
+    updated_at = now()
+WHERE id = $1
+  AND deleted_at IS NOT NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at
+`
+
+type RestoreAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
+}
+
+func (q *Queries) RestoreAuthor(ctx context.Context, id int64) (RestoreAuthorRow, error) {
+	row := q.db.QueryRowContext(ctx, restoreAuthor, id)
+	var i RestoreAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
 	)
-	var i Author
-	err := row.Scan(&i.ID, &i.Name, &i.Bio)
 	return i, err
 }
 
+const revokeApiKey = `-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1
+  AND revoked_at IS NULL
+    RETURNING id, prefix, key_hash, owner, scopes, expires_at, last_used_at, created_at, revoked_at
+`
+
+func (q *Queries) RevokeApiKey(ctx context.Context, id int64) (ApiKey, error) {
+	row := q.db.QueryRowContext(ctx, revokeApiKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Owner,
+		pq.Array(&i.Scopes),
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const searchAuthors = `-- name: SearchAuthors :many
+SELECT id,
+       name,
+       bio,
+       ts_rank(bio_tsv, websearch_to_tsquery('english', $1))::REAL AS rank,
+       ts_headline('english', translate(bio, chr(2) || chr(3), ''), websearch_to_tsquery('english', $1),
+                   'StartSel=' || chr(2) || ', StopSel=' || chr(3))::TEXT AS headline
+FROM authors
+WHERE bio_tsv @@ websearch_to_tsquery('english', $1)
+  AND deleted_at IS NULL
+ORDER BY rank DESC, id
+LIMIT $2
+`
+
+type SearchAuthorsParams struct {
+	Query     string
+	PageLimit int32
+}
+
+type SearchAuthorsRow struct {
+	ID       int64
+	Name     string
+	Bio      string
+	Rank     float32
+	Headline string
+}
+
+// The matches in headline are delimited by STX and ETX, which are taken out
+// of the bio, so the caller can escape it before marking them up.
+func (q *Queries) SearchAuthors(ctx context.Context, arg SearchAuthorsParams) ([]SearchAuthorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchAuthors, arg.Query, arg.PageLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchAuthorsRow
+	for rows.Next() {
+		var i SearchAuthorsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Bio,
+			&i.Rank,
+			&i.Headline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const takeRateLimitToken = `-- name: TakeRateLimitToken :one
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::DOUBLE PRECISION - 1, TRUE, now())
+ON CONFLICT (key) DO UPDATE
+    SET allowed    = rate_limit_refill(b.tokens, b.updated_at, $3::DOUBLE PRECISION, $2) >= 1,
+        tokens     = rate_limit_refill(b.tokens, b.updated_at, $3, $2) -
+                     CASE WHEN rate_limit_refill(b.tokens, b.updated_at, $3, $2) >= 1 THEN 1 ELSE 0 END,
+        updated_at = now()
+    RETURNING tokens, allowed
+`
+
+type TakeRateLimitTokenParams struct {
+	Key   string
+	Burst float64
+	Rate  float64
+}
+
+type TakeRateLimitTokenRow struct {
+	Tokens  float64
+	Allowed bool
+}
+
+// Refills the bucket of key and takes a token from it if there is one.
+func (q *Queries) TakeRateLimitToken(ctx context.Context, arg TakeRateLimitTokenParams) (TakeRateLimitTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, takeRateLimitToken, arg.Key, arg.Burst, arg.Rate)
+	var i TakeRateLimitTokenRow
+	err := row.Scan(&i.Tokens, &i.Allowed)
+	return i, err
+}
+
+const touchApiKey = `-- name: TouchApiKey :exec
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')
+`
+
+// Recording every use would turn each request into a write, so
+// last_used_at is only kept to the minute.
+func (q *Queries) TouchApiKey(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, touchApiKey, id)
+	return err
+}
+
 const truncateAuthor = `-- name: TruncateAuthor :exec
 TRUNCATE authors
 `
@@ -121,21 +623,47 @@ func (q *Queries) TruncateAuthor(ctx context.Context) error {
 
 const updateAuthor = `-- name: UpdateAuthor :one
 UPDATE authors
-SET name = $2,
-    bio  = $3
+SET name       = $2,
+    bio        = $3,
+    version    = version + 1,
+    updated_at = now()
 WHERE id = $1
-    RETURNING id, name, bio
+  AND version = $4
+  AND deleted_at IS NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at
 `
 
 type UpdateAuthorParams struct {
-	ID   int64
-	Name string
-	Bio  string
+	ID      int64
+	Name    string
+	Bio     string
+	Version int64
+}
+
+type UpdateAuthorRow struct {
+	ID        int64
+	Name      string
+	Bio       string
+	Version   int64
+	UpdatedAt time.Time
+	DeletedAt sql.NullTime
 }
 
-func (q *Queries) UpdateAuthor(ctx context.Context, arg UpdateAuthorParams) (Author, error) {
-	row := q.db.QueryRowContext(ctx, updateAuthor, arg.ID, arg.Name, arg.Bio)
-	var i Author
-	err := row.Scan(&i.ID, &i.Name, &i.Bio)
+func (q *Queries) UpdateAuthor(ctx context.Context, arg UpdateAuthorParams) (UpdateAuthorRow, error) {
+	row := q.db.QueryRowContext(ctx, updateAuthor,
+		arg.ID,
+		arg.Name,
+		arg.Bio,
+		arg.Version,
+	)
+	var i UpdateAuthorRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Bio,
+		&i.Version,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
 	return i, err
 }
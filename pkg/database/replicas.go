@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultReplicaCheckInterval = 5 * time.Second
+	defaultReplicaCheckTimeout  = time.Second
+)
+
+// Replica is a read-only copy of the primary kept up to date by streaming
+// replication.
+type Replica struct {
+	// Name identifies the replica in logs.
+	Name string
+	DB   *sql.DB
+}
+
+// RouterOptions configures a Router.
+type RouterOptions struct {
+	// CheckInterval is how often the replicas are pinged, CheckTimeout how
+	// long a ping may take before the replica counts as down.
+	CheckInterval time.Duration
+	CheckTimeout  time.Duration
+	// ReadYourWrites sends the reads of a client to the primary for this
+	// long after it committed a write, so it does not miss its own writes
+	// while the replicas catch up. Zero disables it. Clients are told apart
+	// by the key WithClient attributed their context to; work without one
+	// always reads from the replicas.
+	ReadYourWrites time.Duration
+}
+
+// Router sends the queries of read-only work to the healthy replicas in
+// turn, and to the primary when there are none. Replicas are pinged
+// periodically once Start is called; the ones that fail are skipped until
+// they answer again.
+type Router struct {
+	primary  *Queries
+	replicas []*replica
+	next     atomic.Uint64
+	opts     RouterOptions
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+	now    func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type replica struct {
+	Replica
+	queries *Queries
+	healthy atomic.Bool
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx attributed to the client identified by
+// key, such as the hash of its API key or its IP address.
+func WithClient(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, clientKey{}, key)
+}
+
+func clientFrom(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(clientKey{}).(string)
+	return key, ok && key != ""
+}
+
+// NewRouter routes reads between the primary and replicas, running the
+// queries of the replicas through wrappers like those of the primary.
+func NewRouter(primary *Queries, replicas []Replica, opts RouterOptions, wrappers ...Wrapper) *Router {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaultReplicaCheckInterval
+	}
+	if opts.CheckTimeout <= 0 {
+		opts.CheckTimeout = defaultReplicaCheckTimeout
+	}
+	r := &Router{primary: primary, opts: opts, writes: map[string]time.Time{}, now: time.Now}
+	for _, rep := range replicas {
+		r.replicas = append(r.replicas, &replica{Replica: rep, queries: New(Wrap(rep.DB, wrappers...))})
+	}
+	return r
+}
+
+// Reader returns the queries read-only work of the client of ctx should
+// run on.
+func (r *Router) Reader(ctx context.Context) *Queries {
+	if client, ok := clientFrom(ctx); ok && r.wroteRecently(client) {
+		return r.primary
+	}
+	n := len(r.replicas)
+	start := r.next.Add(1)
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(start+uint64(i))%uint64(n)]
+		if rep.healthy.Load() {
+			return rep.queries
+		}
+	}
+	return r.primary
+}
+
+// Wrote records that the client of ctx committed a write.
+func (r *Router) Wrote(ctx context.Context) {
+	client, ok := clientFrom(ctx)
+	if r.opts.ReadYourWrites <= 0 || !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes[client] = r.now()
+}
+
+func (r *Router) wroteRecently(client string) bool {
+	if r.opts.ReadYourWrites <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wrote, ok := r.writes[client]
+	return ok && r.now().Sub(wrote) < r.opts.ReadYourWrites
+}
+
+// Start checks the replicas once and then keeps checking them in the
+// background until Close.
+func (r *Router) Start() {
+	r.check()
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.opts.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.check()
+				r.forgetWrites()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops checking the replicas and closes their connection pools.
+func (r *Router) Close() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+	}
+	var err error
+	for _, rep := range r.replicas {
+		if closeErr := rep.DB.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (r *Router) check() {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), r.opts.CheckTimeout)
+			defer cancel()
+			err := rep.DB.PingContext(ctx)
+			healthy := err == nil
+			if rep.healthy.Swap(healthy) != healthy {
+				if healthy {
+					slog.Info("Replica is up, reading from it", "replica", rep.Name)
+				} else {
+					slog.Warn("Replica is down, reading from the others or the primary", "replica", rep.Name, "error", err)
+				}
+			}
+		}(rep)
+	}
+	wg.Wait()
+}
+
+// forgetWrites drops the writes too old to matter, so clients that stop
+// writing do not accumulate.
+func (r *Router) forgetWrites() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for client, wrote := range r.writes {
+		if r.now().Sub(wrote) >= r.opts.ReadYourWrites {
+			delete(r.writes, client)
+		}
+	}
+}
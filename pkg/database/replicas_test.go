@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pingDriver connects and answers pings while it is up.
+type pingDriver struct {
+	down atomic.Bool
+}
+
+func (d *pingDriver) Open(string) (driver.Conn, error) {
+	if d.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return pingConn{d}, nil
+}
+
+type pingConn struct{ d *pingDriver }
+
+func (c pingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c pingConn) Close() error                        { return nil }
+func (c pingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+func (c pingConn) Ping(context.Context) error {
+	if c.d.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func openReplica(t *testing.T, name string) (Replica, *pingDriver) {
+	d := &pingDriver{}
+	sql.Register("ping-"+t.Name()+"-"+name, d)
+	db, err := sql.Open("ping-"+t.Name()+"-"+name, "")
+	require.NoError(t, err)
+	return Replica{Name: name, DB: db}, d
+}
+
+func TestRouter_FailsOverToHealthyReplicasAndPrimary(t *testing.T) {
+	one, oneDriver := openReplica(t, "one")
+	two, twoDriver := openReplica(t, "two")
+	primary := New(nil)
+	r := NewRouter(primary, []Replica{one, two}, RouterOptions{CheckInterval: time.Hour})
+	defer r.Close()
+	ctx := context.Background()
+
+	// replicas are unused until a check found them up
+	require.Same(t, primary, r.Reader(ctx))
+
+	r.check()
+	reads := map[*Queries]int{}
+	for i := 0; i < 4; i++ {
+		reads[r.Reader(ctx)]++
+	}
+	require.Equal(t, map[*Queries]int{r.replicas[0].queries: 2, r.replicas[1].queries: 2}, reads)
+
+	oneDriver.down.Store(true)
+	r.check()
+	for i := 0; i < 4; i++ {
+		require.Same(t, r.replicas[1].queries, r.Reader(ctx))
+	}
+
+	twoDriver.down.Store(true)
+	r.check()
+	require.Same(t, primary, r.Reader(ctx))
+
+	oneDriver.down.Store(false)
+	r.check()
+	require.Same(t, r.replicas[0].queries, r.Reader(ctx))
+}
+
+func TestRouter_ReadYourWrites(t *testing.T) {
+	replica, _ := openReplica(t, "replica")
+	primary := New(nil)
+	r := NewRouter(primary, []Replica{replica}, RouterOptions{CheckInterval: time.Hour, ReadYourWrites: 2 * time.Second})
+	defer r.Close()
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	r.check()
+	alice := WithClient(context.Background(), "key:alice")
+	bob := WithClient(context.Background(), "ip:192.0.2.2")
+	unknown := context.Background()
+
+	r.Wrote(alice)
+	r.Wrote(unknown)
+
+	// only the client that wrote reads from the primary
+	require.Same(t, primary, r.Reader(alice))
+	require.Same(t, r.replicas[0].queries, r.Reader(bob))
+	require.Same(t, r.replicas[0].queries, r.Reader(unknown))
+	require.Len(t, r.writes, 1)
+
+	now = now.Add(2 * time.Second)
+	require.Same(t, r.replicas[0].queries, r.Reader(alice))
+	r.forgetWrites()
+	require.Empty(t, r.writes)
+}
+
+func TestTxManager_ReadsFromRouterAndReportsWrites(t *testing.T) {
+	replica, _ := openReplica(t, "replica")
+	m := newCountingTxManager(t, &countingDriver{})
+	r := NewRouter(m.Queries(), []Replica{replica}, RouterOptions{CheckInterval: time.Hour, ReadYourWrites: time.Minute})
+	defer r.Close()
+	r.check()
+	ctx := WithClient(context.Background(), "key:alice")
+
+	require.Same(t, m.Queries(), m.ReadQueries(ctx))
+	m.ReadFrom(r)
+	require.Same(t, r.replicas[0].queries, m.ReadQueries(ctx))
+
+	require.NoError(t, m.InTx(ctx, func(q *Queries) error { return nil }))
+	require.Same(t, m.Queries(), m.ReadQueries(ctx))
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultTxAttempts = 3
+	txRetryBaseDelay  = 10 * time.Millisecond
+)
+
+// TxManager runs units of work inside database transactions. A unit of work
+// gets a Queries bound to its transaction; the transaction is committed when
+// the unit of work returns nil and rolled back otherwise.
+type TxManager struct {
+	db       *sql.DB
+	queries  *Queries
+	wrappers []Wrapper
+	attempts int
+	router   *Router
+}
+
+// NewTxManager runs queries on db, and inside its transactions, through
+// wrappers, the first wrapping innermost.
+func NewTxManager(db *sql.DB, wrappers ...Wrapper) *TxManager {
+	return &TxManager{db: db, queries: New(Wrap(db, wrappers...)), wrappers: wrappers, attempts: defaultTxAttempts}
+}
+
+// Queries returns queries that run outside of any transaction.
+func (m *TxManager) Queries() *Queries {
+	return m.queries
+}
+
+// ReadFrom routes the queries of ReadQueries through router and tells it
+// about every committed transaction.
+func (m *TxManager) ReadFrom(router *Router) {
+	m.router = router
+}
+
+// ReadQueries returns queries for read-only work outside of any
+// transaction, which may run on a replica and so see data slightly behind
+// the primary.
+func (m *TxManager) ReadQueries(ctx context.Context) *Queries {
+	if m.router == nil {
+		return m.queries
+	}
+	return m.router.Reader(ctx)
+}
+
+// InTx runs fn in a read committed transaction.
+func (m *TxManager) InTx(ctx context.Context, fn func(q *Queries) error) error {
+	return m.RunInTx(ctx, nil, fn)
+}
+
+// RunInTx runs fn in a transaction started with opts. When the transaction
+// fails with a serialization failure or a deadlock it is retried from the
+// start, so fn must not have side effects outside of the transaction.
+func (m *TxManager) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(q *Queries) error) error {
+	var err error
+	for attempt := 0; attempt < m.attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, retryDelay(attempt)); err != nil {
+				return err
+			}
+		}
+		if err = m.runOnce(ctx, opts, fn); !IsRetryable(err) {
+			if err == nil && m.router != nil && (opts == nil || !opts.ReadOnly) {
+				m.router.Wrote(ctx)
+			}
+			return err
+		}
+		slog.WarnContext(ctx, "Retrying transaction", "attempt", attempt+1, "error", err)
+	}
+	return err
+}
+
+func (m *TxManager) runOnce(ctx context.Context, opts *sql.TxOptions, fn func(q *Queries) error) error {
+	tx, err := m.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := fn(New(Wrap(tx, m.wrappers...))); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Savepoint runs fn inside a savepoint of the transaction q is bound to. When
+// fn fails only its own changes are rolled back, and the transaction can go
+// on with other work.
+func (q *Queries) Savepoint(ctx context.Context, fn func() error) error {
+	if _, err := q.db.ExecContext(ctx, "SAVEPOINT unit_of_work"); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := q.db.ExecContext(ctx, "ROLLBACK TO SAVEPOINT unit_of_work"); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	_, err := q.db.ExecContext(ctx, "RELEASE SAVEPOINT unit_of_work")
+	return err
+}
+
+// retryDelay backs off exponentially with full jitter, so transactions that
+// conflicted with each other do not collide again.
+func retryDelay(attempt int) time.Duration {
+	return time.Duration(rand.Int63n(int64(txRetryBaseDelay << attempt)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// countingDriver is a database/sql driver that only supports transactions
+// and counts what happens to them.
+type countingDriver struct {
+	begins, commits, rollbacks int
+	commitErrs                 []error
+}
+
+func (d *countingDriver) Open(string) (driver.Conn, error) { return &countingConn{d}, nil }
+
+type countingConn struct{ d *countingDriver }
+
+func (c *countingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *countingConn) Close() error                        { return nil }
+func (c *countingConn) Begin() (driver.Tx, error) {
+	c.d.begins++
+	return c, nil
+}
+func (c *countingConn) Commit() error {
+	c.d.commits++
+	if len(c.d.commitErrs) > 0 {
+		err := c.d.commitErrs[0]
+		c.d.commitErrs = c.d.commitErrs[1:]
+		return err
+	}
+	return nil
+}
+func (c *countingConn) Rollback() error {
+	c.d.rollbacks++
+	return nil
+}
+
+func newCountingTxManager(t *testing.T, d *countingDriver) *TxManager {
+	name := "counting-" + t.Name()
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewTxManager(db)
+}
+
+func TestTxManager_CommitsOnSuccess(t *testing.T) {
+	d := &countingDriver{}
+	m := newCountingTxManager(t, d)
+
+	require.NoError(t, m.InTx(context.Background(), func(q *Queries) error { return nil }))
+	require.Equal(t, 1, d.begins)
+	require.Equal(t, 1, d.commits)
+	require.Equal(t, 0, d.rollbacks)
+}
+
+func TestTxManager_RollsBackOnError(t *testing.T) {
+	d := &countingDriver{}
+	m := newCountingTxManager(t, d)
+	failure := errors.New("failure")
+
+	require.ErrorIs(t, m.InTx(context.Background(), func(q *Queries) error { return failure }), failure)
+	require.Equal(t, 1, d.begins)
+	require.Equal(t, 0, d.commits)
+	require.Equal(t, 1, d.rollbacks)
+}
+
+func TestTxManager_RetriesSerializationFailures(t *testing.T) {
+	d := &countingDriver{commitErrs: []error{&pq.Error{Code: "40001"}}}
+	m := newCountingTxManager(t, d)
+
+	calls := 0
+	err := m.InTx(context.Background(), func(q *Queries) error {
+		calls++
+		if calls == 1 {
+			return &pq.Error{Code: "40P01"}
+		}
+		return nil
+	})
+
+	// deadlock in the first attempt, serialization failure on the first
+	// commit, success on the third attempt
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, 3, d.begins)
+	require.Equal(t, 2, d.commits)
+}
+
+func TestTxManager_GivesUpAfterMaxAttempts(t *testing.T) {
+	d := &countingDriver{}
+	m := newCountingTxManager(t, d)
+
+	calls := 0
+	err := m.InTx(context.Background(), func(q *Queries) error {
+		calls++
+		return &pq.Error{Code: "40001"}
+	})
+
+	require.Error(t, err)
+	require.Equal(t, defaultTxAttempts, calls)
+}
+
+func TestTxManager_DoesNotRetryOtherErrors(t *testing.T) {
+	d := &countingDriver{}
+	m := newCountingTxManager(t, d)
+
+	calls := 0
+	err := m.InTx(context.Background(), func(q *Queries) error {
+		calls++
+		return &pq.Error{Code: "23505"}
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
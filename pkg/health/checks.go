@@ -0,0 +1,28 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/potatowhite/restfulapi/pkg/database"
+)
+
+// Ping checks that db accepts connections.
+func Ping(db *sql.DB) Check {
+	return db.PingContext
+}
+
+// Migrations checks that every migration the service knows has been
+// applied, so it does not serve requests against an older schema.
+func Migrations(migrator *database.Migrator) Check {
+	return func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("migrations %v are pending", pending)
+		}
+		return nil
+	}
+}
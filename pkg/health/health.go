@@ -0,0 +1,131 @@
+// Package health serves the liveness and readiness endpoints probed by the
+// container orchestrator.
+package health
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout bounds each readiness check when no timeout is configured.
+const DefaultTimeout = 2 * time.Second
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Check reports whether a dependency is usable. It has to return once ctx
+// is done.
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single check. Why a check failed is only
+// logged, as the endpoint is not authenticated.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Report is the answer of the readiness endpoint.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks.
+type Checker struct {
+	timeout      time.Duration
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{timeout: timeout}
+}
+
+// Add has readiness depend on check.
+func (h *Checker) Add(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// ShuttingDown fails readiness from now on, so no new requests are routed
+// to a server that is about to stop.
+func (h *Checker) ShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Ready runs every check concurrently, each bounded by the timeout.
+func (h *Checker) Ready(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: map[string]CheckResult{}}
+	if h.shuttingDown.Load() {
+		report.Status = StatusFailing
+		report.Checks["shutdown"] = CheckResult{Status: StatusFailing}
+		return report
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			result, err := h.run(ctx, c.check)
+			if err != nil {
+				slog.WarnContext(ctx, "Readiness check failed", "check", c.name, "error", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFailing
+			}
+		}(c)
+	}
+	wg.Wait()
+	return report
+}
+
+func (h *Checker) run(ctx context.Context, check Check) (CheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	start := time.Now()
+	err := check(ctx)
+	result := CheckResult{Status: StatusOK, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		result.Status = StatusFailing
+	}
+	return result, err
+}
+
+// RegisterHandlers serves liveness at /healthz, which only tells the
+// process is able to answer, and readiness at /readyz, which answers 503
+// while any check fails.
+func (h *Checker) RegisterHandlers(router gin.IRouter) {
+	router.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": StatusOK})
+	})
+	router.GET("/readyz", func(c *gin.Context) {
+		report := h.Ready(c.Request.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(status, report)
+	})
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, checker *Checker, path string) (int, Report) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	checker.RegisterHandlers(router)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var report Report
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	return rec.Code, report
+}
+
+func TestReady(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", func(context.Context) error { return nil })
+	checker.Add("migrations", func(context.Context) error { return nil })
+
+	status, report := serve(t, checker, "/readyz")
+
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, StatusOK, report.Status)
+	require.Len(t, report.Checks, 2)
+	require.Equal(t, StatusOK, report.Checks["database"].Status)
+}
+
+func TestReady_FailingAndTimedOutChecks(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	checker.Add("migrations", func(context.Context) error { return errors.New("migrations [9] are pending") })
+	checker.Add("other", func(context.Context) error { return nil })
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	start := time.Now()
+	status, report := serve(t, checker, "/readyz")
+
+	require.Less(t, time.Since(start), time.Second)
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, StatusFailing, report.Status)
+	require.Equal(t, StatusFailing, report.Checks["migrations"].Status)
+	require.Equal(t, StatusFailing, report.Checks["database"].Status)
+	require.GreaterOrEqual(t, report.Checks["database"].LatencyMS, 50.0)
+	// the errors are logged rather than served
+	require.Contains(t, logs.String(), `check=migrations error="migrations [9] are pending"`)
+	require.Contains(t, logs.String(), "check=database error=\"context deadline exceeded\"")
+	require.Equal(t, StatusOK, report.Checks["other"].Status)
+}
+
+func TestReady_FailsOnceShuttingDown(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("database", func(context.Context) error { return nil })
+
+	checker.ShuttingDown()
+
+	status, report := serve(t, checker, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, StatusFailing, report.Checks["shutdown"].Status)
+
+	// the process is still alive
+	status, report = serve(t, checker, "/healthz")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, StatusOK, report.Status)
+}
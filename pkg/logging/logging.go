@@ -0,0 +1,84 @@
+// Package logging configures the structured logger shared by the service,
+// correlates log records with the request they belong to and keeps secrets
+// out of them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Options configures the logger.
+type Options struct {
+	// Level is "debug", "info", "warn" or "error".
+	Level string
+	// Format is "json" or "text".
+	Format string
+}
+
+// New returns a logger writing records of at least opts.Level to w. Every
+// record carries the request ID and trace of the context it is logged with,
+// and attributes named like secrets are redacted.
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", opts.Level)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case FormatJSON, "":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Setup makes a logger writing to stdout the default one, which the
+// standard log package writes through as well.
+func Setup(opts Options) error {
+	logger, err := New(os.Stdout, opts)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// contextHandler adds the request ID and the trace and span IDs found in the
+// context of a record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := RequestIDFrom(ctx); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Level: "info", Format: FormatJSON})
+	require.NoError(t, err)
+
+	logger.DebugContext(context.Background(), "hidden")
+	logger.InfoContext(WithRequestID(context.Background(), "req-1"), "shown", "id", 1)
+
+	records := decode(t, &buf)
+	require.Len(t, records, 1)
+	require.Equal(t, "shown", records[0]["msg"])
+	require.Equal(t, "req-1", records[0]["request_id"])
+
+	_, err = New(&buf, Options{Level: "loud"})
+	require.Error(t, err)
+	_, err = New(&buf, Options{Level: "info", Format: "xml"})
+	require.Error(t, err)
+}
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Level: "info", Format: FormatJSON})
+	require.NoError(t, err)
+	config := struct {
+		Host     string
+		Password Secret
+	}{"localhost", "hunter2"}
+
+	logger.Info("configured", "hmac_secret", "hunter2", "Authorization", "Bearer hunter2", "config", config, "password", Secret("hunter2"))
+
+	require.NotContains(t, buf.String(), "hunter2")
+	records := decode(t, &buf)
+	require.Equal(t, Redacted, records[0]["hmac_secret"])
+	require.Equal(t, Redacted, records[0]["Authorization"])
+	require.Equal(t, map[string]interface{}{"Host": "localhost", "Password": Redacted}, records[0]["config"])
+
+	buf.Reset()
+	text, err := New(&buf, Options{Level: "info", Format: FormatText})
+	require.NoError(t, err)
+	text.Info("configured", "config", config)
+	require.NotContains(t, buf.String(), "hunter2")
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Options{Level: "info"})
+	require.NoError(t, err)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/authors/:id", func(c *gin.Context) {
+		id, ok := RequestIDFrom(c.Request.Context())
+		require.True(t, ok)
+		c.String(http.StatusOK, id)
+	})
+	send := func(id string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/authors/1", nil)
+		if id != "" {
+			request.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send("upstream-42")
+	require.Equal(t, "upstream-42", rec.Header().Get(RequestIDHeader))
+	require.Equal(t, "upstream-42", rec.Body.String())
+
+	// missing and malformed IDs are replaced by generated ones
+	for _, id := range []string{"", "forged\nline", strings.Repeat("x", maxRequestIDLength+1)} {
+		rec = send(id)
+		generated := rec.Header().Get(RequestIDHeader)
+		require.Len(t, generated, 32, id)
+		require.Equal(t, generated, rec.Body.String(), id)
+	}
+
+	records := decode(t, &buf)
+	require.Len(t, records, 4)
+	require.Equal(t, "request", records[0]["msg"])
+	require.Equal(t, "upstream-42", records[0]["request_id"])
+	require.Equal(t, "/authors/:id", records[0]["route"])
+	require.EqualValues(t, http.StatusOK, records[0]["status"])
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// Redacted replaces the values of secrets in log records.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the substrings of attribute names whose values are
+// never logged.
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "api_key", "apikey", "cookie"}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(attr.Key, Redacted)
+		}
+	}
+	return attr
+}
+
+// Secret is a string that is redacted whenever it is logged or printed,
+// also as a field of a struct such as the configuration. Convert it to a
+// string to use it.
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"time"
+)
+
+// unnamedQuery labels statements that did not come from sqlc, such as
+// savepoints.
+const unnamedQuery = "unnamed"
+
+// DBTX times the queries run on db by their sqlc name. It is a
+// database.Wrapper.
+func (m *Metrics) DBTX(db database.DBTX) database.DBTX {
+	return &timedDBTX{db: db, metrics: m}
+}
+
+type timedDBTX struct {
+	db      database.DBTX
+	metrics *Metrics
+}
+
+func (t *timedDBTX) observe(query string, start time.Time, err error) {
+	name := database.QueryName(query)
+	if name == "" {
+		name = unnamedQuery
+	}
+	outcome := "ok"
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		outcome = "error"
+	}
+	t.metrics.queries.WithLabelValues(name, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (t *timedDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.db.ExecContext(ctx, query, args...)
+	t.observe(query, start, err)
+	return result, err
+}
+
+func (t *timedDBTX) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.db.PrepareContext(ctx, query)
+}
+
+// QueryContext only times the query until its first rows arrive; reading
+// them is up to the caller.
+func (t *timedDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	t.observe(query, start, err)
+	return rows, err
+}
+
+func (t *timedDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := t.db.QueryRowContext(ctx, query, args...)
+	t.observe(query, start, row.Err())
+	return row
+}
@@ -0,0 +1,82 @@
+// Package metrics exposes Prometheus metrics of the HTTP handlers, the
+// database pool and the queries run on it.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels requests no route matched, so arbitrary paths do
+// not each create a series.
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the collectors of the service in a registry of its own.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	queries  *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route template, method and status.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route template, method and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Latency of database queries by sqlc query name and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"query", "outcome"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.queries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// CollectDBStats exports the sql.DBStats of db, such as the open and
+// in-use connections and how often and long callers waited for one, as
+// the go_sql_* metrics labeled db_name=name.
+func (m *Metrics) CollectDBStats(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Middleware counts and times every request. It has to run before anything
+// that can abort a request, so those are measured too.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		labels := prometheus.Labels{"route": route, "method": c.Request.Method, "status": strconv.Itoa(c.Writer.Status())}
+		m.requests.With(labels).Inc()
+		m.duration.With(labels).Observe(time.Since(start).Seconds())
+	}
+}
+
+// RegisterHandlers serves the metrics at /metrics.
+func (m *Metrics) RegisterHandlers(router gin.IRouter) {
+	router.GET("/metrics", gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})))
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware_LabelsByRouteTemplate(t *testing.T) {
+	m := New()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(m.Middleware())
+	m.RegisterHandlers(router)
+	router.GET("/authors/:id", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	for _, path := range []string{"/authors/1", "/authors/2", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	require.Equal(t, 2.0, testutil.ToFloat64(m.requests.WithLabelValues("/authors/:id", "GET", "200")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues(unmatchedRoute, "GET", "404")))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `http_request_duration_seconds_count{method="GET",route="/authors/:id",status="200"} 2`)
+	require.NotContains(t, rec.Body.String(), "/authors/1")
+}
+
+type fakeDBTX struct {
+	database.DBTX
+	err error
+}
+
+func (f fakeDBTX) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// sampleCount returns how many queries were observed with query and outcome.
+func sampleCount(t *testing.T, m *Metrics, query, outcome string) uint64 {
+	families, err := m.registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "db_query_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["query"] == query && labels["outcome"] == outcome {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestDBTX_TimesQueriesByName(t *testing.T) {
+	m := New()
+
+	_, _ = database.New(m.DBTX(fakeDBTX{})).PurgeAuthor(context.Background(), 1)
+	_, _ = database.New(m.DBTX(fakeDBTX{})).PurgeAuthor(context.Background(), 2)
+	_, _ = database.New(m.DBTX(fakeDBTX{err: sql.ErrConnDone})).PurgeAuthor(context.Background(), 1)
+	_, _ = m.DBTX(fakeDBTX{}).ExecContext(context.Background(), "SAVEPOINT unit_of_work")
+
+	require.EqualValues(t, 2, sampleCount(t, m, "PurgeAuthor", "ok"))
+	require.EqualValues(t, 1, sampleCount(t, m, "PurgeAuthor", "error"))
+	require.EqualValues(t, 1, sampleCount(t, m, unnamedQuery, "ok"))
+}
+
+func TestCollectDBStats(t *testing.T) {
+	m := New()
+	db, err := sql.Open("postgres", "host=localhost")
+	require.NoError(t, err)
+	defer db.Close()
+
+	m.CollectDBStats(db, "authors")
+
+	for _, name := range []string{"go_sql_open_connections", "go_sql_in_use_connections", "go_sql_wait_count_total", "go_sql_wait_duration_seconds_total"} {
+		count, err := testutil.GatherAndCount(m.registry, name)
+		require.NoError(t, err)
+		require.Equal(t, 1, count, name)
+	}
+}
@@ -0,0 +1,64 @@
+package apikeys
+
+import (
+	"database/sql"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"time"
+)
+
+// APIKey describes an issued key. The key itself is never stored, so only
+// its prefix can be shown.
+type APIKey struct {
+	ID     int64    `json:"id"`
+	Prefix string   `json:"prefix"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is the answer to creating a key, the only time the key is
+// shown.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateRequest issues a key to Owner. Scopes are the roles the key acts
+// with; a key without ExpiresAt is valid until it is revoked.
+type CreateRequest struct {
+	Owner     string     `json:"owner" binding:"required,max=128"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyList struct {
+	Items []*APIKey `json:"items"`
+}
+
+type PathParameters struct {
+	ID int64 `uri:"id" binding:"required"`
+}
+
+func fromDB(key database.ApiKey) *APIKey {
+	return &APIKey{
+		ID:         key.ID,
+		Prefix:     key.Prefix,
+		Owner:      key.Owner,
+		Scopes:     key.Scopes,
+		ExpiresAt:  timePtr(key.ExpiresAt),
+		LastUsedAt: timePtr(key.LastUsedAt),
+		CreatedAt:  key.CreatedAt,
+		RevokedAt:  timePtr(key.RevokedAt),
+	}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,98 @@
+package apikeys
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"net/http"
+	"time"
+)
+
+// PermissionAdmin is required by every API key route.
+const PermissionAdmin auth.Permission = "apikeys:admin"
+
+type APIKeyHandler interface {
+	RegisterHandlers(router gin.IRouter)
+}
+
+type apiKeyHandler struct {
+	service APIKeyService
+	policy  *auth.Policy
+}
+
+// NewAPIKeyHandler serves the API key admin routes to the roles policy
+// grants PermissionAdmin. Keys can only be issued with scopes naming roles
+// of policy; a nil policy allows every route and any scope.
+func NewAPIKeyHandler(service APIKeyService, policy *auth.Policy) APIKeyHandler {
+	return &apiKeyHandler{service: service, policy: policy}
+}
+
+func (h *apiKeyHandler) RegisterHandlers(router gin.IRouter) {
+	admin := h.policy.Require(PermissionAdmin)
+
+	router.POST("/api-keys", admin, h.Create)
+	router.GET("/api-keys", admin, h.List)
+	router.DELETE("/api-keys/:id", admin, h.Revoke)
+}
+
+func (h *apiKeyHandler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+	var fields []apperror.FieldError
+	for _, scope := range req.Scopes {
+		if h.policy != nil && !h.policy.Defines(scope) {
+			fields = append(fields, apperror.FieldError{Field: "scopes", Code: "unknown_role", Message: "unknown role " + scope})
+		}
+	}
+	if expired(req.ExpiresAt) {
+		fields = append(fields, apperror.FieldError{Field: "expires_at", Code: "expired", Message: "must be in the future"})
+	}
+	if len(fields) > 0 {
+		abort(c, apperror.Validation("invalid_api_key_request", "the API key request is invalid", fields...))
+		return
+	}
+
+	created, err := h.service.Create(c.Request.Context(), req)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *apiKeyHandler) List(c *gin.Context) {
+	keys, err := h.service.List(c.Request.Context())
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, APIKeyList{Items: keys})
+}
+
+func (h *apiKeyHandler) Revoke(c *gin.Context) {
+	var pathParams PathParameters
+	if err := c.ShouldBindUri(&pathParams); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+	if err := h.service.Revoke(c.Request.Context(), pathParams.ID); err != nil {
+		abort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// expired reports whether t has passed, for rejecting keys that would be
+// born expired.
+func expired(t *time.Time) bool {
+	return t != nil && !t.After(time.Now())
+}
@@ -0,0 +1,145 @@
+package apikeys
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/cmd/config"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type APIKeyTestSuite struct {
+	suite.Suite
+	router  *gin.Engine
+	service APIKeyService
+	db      *database.Postgres
+}
+
+func TestAPIKeyTestSuite(t *testing.T) {
+	suite.Run(t, new(APIKeyTestSuite))
+}
+
+func (s *APIKeyTestSuite) SetupSuite() {
+	cfg, err := config.Read()
+	s.Require().NoError(err)
+
+	s.db, err = database.NewPostgres(database.Options{URL: string(cfg.Database.URL), Host: cfg.Database.Host, Port: cfg.Database.Port, User: cfg.Database.Username, Password: string(cfg.Database.Password), Dbname: cfg.Database.Dbname, SSLMode: cfg.Database.SSLMode})
+	s.Require().NoError(err)
+
+	migrator, err := database.NewMigrator(s.db.DB)
+	s.Require().NoError(err)
+	s.Require().NoError(migrator.Up(context.Background()))
+
+	s.service = NewAPIKeyService(database.NewTxManager(s.db.DB))
+	policy := auth.NewPolicy(map[string][]string{"admin": {string(PermissionAdmin)}, "editor": {"authors:write"}})
+
+	gin.SetMode(gin.TestMode)
+	s.router = gin.New()
+	s.router.Use(apperror.Middleware())
+	s.router.Use(auth.Middleware(nil, s.service))
+	NewAPIKeyHandler(s.service, policy).RegisterHandlers(s.router)
+}
+
+func (s *APIKeyTestSuite) SetupTest() {
+	_, err := s.db.DB.Exec("TRUNCATE api_keys")
+	s.Require().NoError(err)
+}
+
+// adminKey bootstraps a key to call the admin routes with.
+func (s *APIKeyTestSuite) adminKey() string {
+	created, err := s.service.Create(context.Background(), CreateRequest{Owner: "ops", Scopes: []string{"admin"}})
+	s.Require().NoError(err)
+	return created.Key
+}
+
+func (s *APIKeyTestSuite) send(method, path, key string, body interface{}) *httptest.ResponseRecorder {
+	var buffer bytes.Buffer
+	if body != nil {
+		s.Require().NoError(json.NewEncoder(&buffer).Encode(body))
+	}
+	request := httptest.NewRequest(method, path, &buffer)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(auth.APIKeyHeader, key)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	return rec
+}
+
+func (s *APIKeyTestSuite) TestCreateShowsKeyOnce() {
+	admin := s.adminKey()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	rec := s.send(http.MethodPost, "/api-keys", admin, CreateRequest{Owner: "importer", Scopes: []string{"editor"}, ExpiresAt: &expiresAt})
+	s.Require().Equal(http.StatusCreated, rec.Code, rec.Body.String())
+	s.Require().Equal("no-store", rec.Header().Get("Cache-Control"))
+	var created CreatedAPIKey
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&created))
+	s.Require().True(wellFormed(created.Key))
+	s.Require().Equal("importer", created.Owner)
+	s.Require().True(expiresAt.Equal(*created.ExpiresAt))
+
+	principal, err := s.service.Authenticate(context.Background(), created.Key)
+	s.Require().NoError(err)
+	s.Require().Equal(&auth.Principal{Subject: "importer", Roles: []string{"editor"}}, principal)
+
+	rec = s.send(http.MethodGet, "/api-keys", admin, nil)
+	s.Require().Equal(http.StatusOK, rec.Code)
+	s.Require().NotContains(rec.Body.String(), created.Key)
+	var list APIKeyList
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&list))
+	s.Require().Len(list.Items, 2)
+	s.Require().Equal(created.Prefix, list.Items[1].Prefix)
+	s.Require().NotNil(list.Items[1].LastUsedAt)
+}
+
+func (s *APIKeyTestSuite) TestCreateRejectsUnknownScopesAndPastExpiry() {
+	expiresAt := time.Now().Add(-time.Hour)
+
+	rec := s.send(http.MethodPost, "/api-keys", s.adminKey(), CreateRequest{Owner: "importer", Scopes: []string{"superuser"}, ExpiresAt: &expiresAt})
+
+	s.Require().Equal(http.StatusBadRequest, rec.Code)
+	var problem apperror.Problem
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&problem))
+	s.Require().Len(problem.Errors, 2)
+}
+
+func (s *APIKeyTestSuite) TestRevoke() {
+	admin := s.adminKey()
+	created, err := s.service.Create(context.Background(), CreateRequest{Owner: "importer", Scopes: []string{"editor"}})
+	s.Require().NoError(err)
+	path := "/api-keys/" + strconv.FormatInt(created.ID, 10)
+
+	s.Require().Equal(http.StatusNoContent, s.send(http.MethodDelete, path, admin, nil).Code)
+	s.Require().Equal(http.StatusNotFound, s.send(http.MethodDelete, path, admin, nil).Code)
+
+	_, err = s.service.Authenticate(context.Background(), created.Key)
+	s.Require().Equal(auth.ErrInvalidAPIKey, err)
+}
+
+func (s *APIKeyTestSuite) TestAuthenticateRejectsExpiredKeys() {
+	created, err := s.service.Create(context.Background(), CreateRequest{Owner: "importer", Scopes: []string{"editor"}})
+	s.Require().NoError(err)
+	_, err = s.db.DB.Exec("UPDATE api_keys SET expires_at = now() - INTERVAL '1 second' WHERE id = $1", created.ID)
+	s.Require().NoError(err)
+
+	_, err = s.service.Authenticate(context.Background(), created.Key)
+
+	s.Require().Equal(auth.ErrInvalidAPIKey, err)
+}
+
+func (s *APIKeyTestSuite) TestAdminRoutesRequirePermission() {
+	created, err := s.service.Create(context.Background(), CreateRequest{Owner: "importer", Scopes: []string{"editor"}})
+	s.Require().NoError(err)
+
+	s.Require().Equal(http.StatusForbidden, s.send(http.MethodGet, "/api-keys", created.Key, nil).Code)
+	s.Require().Equal(http.StatusUnauthorized, s.send(http.MethodGet, "/api-keys", "ak_unknown", nil).Code)
+}
@@ -0,0 +1,45 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	// keyPrefix marks API keys, so they are recognisable in config files and
+	// secret scanners, and so anything else is rejected without a lookup.
+	keyPrefix = "ak_"
+	// keyBytes of randomness make keys unguessable, which is also why a
+	// plain SHA-256 hash is enough to store them: unlike passwords there is
+	// no dictionary to try.
+	keyBytes = 32
+	// displayLength is how much of a key is kept in the clear to tell keys
+	// apart in listings.
+	displayLength = len(keyPrefix) + 8
+)
+
+// generateKey returns a new random key, its display prefix and its hash.
+func generateKey() (key string, prefix string, hash []byte, err error) {
+	b := make([]byte, keyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:displayLength], hashKey(key), nil
+}
+
+func hashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// wellFormed reports whether key could have been made by generateKey.
+func wellFormed(key string) bool {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(key[len(keyPrefix):])
+	return err == nil && len(b) == keyBytes
+}
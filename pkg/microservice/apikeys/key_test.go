@@ -0,0 +1,30 @@
+package apikeys
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	key, prefix, hash, err := generateKey()
+	require.NoError(t, err)
+
+	require.True(t, wellFormed(key))
+	require.True(t, strings.HasPrefix(key, prefix))
+	require.Len(t, prefix, displayLength)
+	require.Equal(t, hashKey(key), hash)
+
+	other, _, otherHash, err := generateKey()
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+	require.NotEqual(t, hash, otherHash)
+}
+
+func TestWellFormed(t *testing.T) {
+	require.False(t, wellFormed(""))
+	require.False(t, wellFormed("ak_"))
+	require.False(t, wellFormed("ak_tooshort"))
+	require.False(t, wellFormed("xx_"+strings.Repeat("A", 43)))
+	require.True(t, wellFormed("ak_"+strings.Repeat("A", 43)))
+}
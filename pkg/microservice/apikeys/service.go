@@ -0,0 +1,115 @@
+// Package apikeys issues API keys for clients that cannot obtain bearer
+// tokens, and authenticates requests made with them.
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/potatowhite/restfulapi/pkg/actor"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"log/slog"
+)
+
+// ErrAPIKeyNotFound is returned when revoking a key that does not exist or
+// has already been revoked.
+var ErrAPIKeyNotFound = apperror.NotFound("api_key_not_found", "API key not found")
+
+type APIKeyService interface {
+	auth.APIKeys
+	Create(ctx context.Context, req CreateRequest) (*CreatedAPIKey, error)
+	List(ctx context.Context) ([]*APIKey, error)
+	Revoke(ctx context.Context, id int64) error
+}
+
+type apiKeyService struct {
+	tx *database.TxManager
+}
+
+func NewAPIKeyService(tx *database.TxManager) APIKeyService {
+	return &apiKeyService{tx: tx}
+}
+
+func (s *apiKeyService) Create(ctx context.Context, req CreateRequest) (*CreatedAPIKey, error) {
+	key, prefix, hash, err := generateKey()
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error generating API key: %w", err))
+	}
+	var expiresAt sql.NullTime
+	if req.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	created, err := s.tx.Queries().CreateApiKey(ctx, database.CreateApiKeyParams{
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Owner:     req.Owner,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error creating API key: %w", err))
+	}
+	slog.InfoContext(ctx, "API key created", "id", created.ID, "prefix", prefix, "owner", req.Owner, "scopes", req.Scopes, "actor", actor.From(ctx))
+	return &CreatedAPIKey{APIKey: *fromDB(created), Key: key}, nil
+}
+
+func (s *apiKeyService) List(ctx context.Context) ([]*APIKey, error) {
+	keys, err := s.tx.Queries().ListApiKeys(ctx)
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error listing API keys: %w", err))
+	}
+	items := make([]*APIKey, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, fromDB(key))
+	}
+	return items, nil
+}
+
+func (s *apiKeyService) Revoke(ctx context.Context, id int64) error {
+	revoked, err := s.tx.Queries().RevokeApiKey(ctx, id)
+	if err != nil {
+		return logging(ctx, fmt.Errorf("error revoking API key %d: %w", id, err))
+	}
+	slog.InfoContext(ctx, "API key revoked", "id", revoked.ID, "prefix", revoked.Prefix, "owner", revoked.Owner, "actor", actor.From(ctx))
+	return nil
+}
+
+// Authenticate returns the owner of key as the principal, with the key's
+// scopes as its roles.
+func (s *apiKeyService) Authenticate(ctx context.Context, key string) (*auth.Principal, error) {
+	if !wellFormed(key) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	found, err := s.tx.Queries().GetApiKeyByHash(ctx, hashKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error looking up API key: %w", err))
+	}
+	if err := s.tx.Queries().TouchApiKey(ctx, found.ID); err != nil {
+		// not worth failing the request over
+		slog.WarnContext(ctx, "error recording use of API key", "id", found.ID, "error", err)
+	}
+	return &auth.Principal{Subject: found.Owner, Roles: found.Scopes}, nil
+}
+
+// logging logs err and translates it into the domain error callers see, so
+// database details never leave the service.
+func logging(ctx context.Context, err error) error {
+	slog.ErrorContext(ctx, "API key service call failed", "error", err)
+	err = database.TranslateError(err)
+	var appErr *apperror.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrAPIKeyNotFound
+	case errors.As(err, &appErr):
+		return appErr
+	default:
+		return apperror.Internal(err)
+	}
+}
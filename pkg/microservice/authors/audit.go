@@ -0,0 +1,63 @@
+package authors
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/potatowhite/restfulapi/pkg/actor"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"time"
+)
+
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditPatch   = "patch"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditPurge   = "purge"
+)
+
+// authorSnapshot is the state of an author as recorded in the audit trail.
+type authorSnapshot struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Bio       string     `json:"bio"`
+	Version   int64      `json:"version"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// audit records a mutation of an author, attributed to the actor of ctx.
+// before is nil for creations and after is nil for purges. It must be called
+// with the same transaction-bound queries as the mutation itself.
+func audit(ctx context.Context, q *database.Queries, action string, before, after *database.Author) error {
+	authorID := before
+	if authorID == nil {
+		authorID = after
+	}
+	return q.CreateAuthorAudit(ctx, database.CreateAuthorAuditParams{
+		AuthorID: authorID.ID,
+		Action:   action,
+		Actor:    actor.From(ctx),
+		Before:   snapshot(before),
+		After:    snapshot(after),
+	})
+}
+
+func snapshot(author *database.Author) json.RawMessage {
+	if author == nil {
+		return json.RawMessage("null")
+	}
+	s := authorSnapshot{
+		ID:        author.ID,
+		Name:      author.Name,
+		Bio:       author.Bio,
+		Version:   author.Version,
+		UpdatedAt: author.UpdatedAt,
+	}
+	if author.DeletedAt.Valid {
+		s.DeletedAt = &author.DeletedAt.Time
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
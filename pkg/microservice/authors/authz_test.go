@@ -0,0 +1,82 @@
+package authors
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// permittedService answers every call the handlers make once a request got
+// past authorization; the calls themselves are not under test.
+type permittedService struct {
+	AuthorService
+}
+
+func (permittedService) Get(context.Context, int64) (*Author, error) {
+	return &Author{ID: 1, Name: "name", Bio: "bio", Version: 1}, nil
+}
+
+func (permittedService) Create(context.Context, database.CreateAuthorParams) (*Author, error) {
+	return &Author{ID: 1, Name: "name", Bio: "bio", Version: 1}, nil
+}
+
+func (permittedService) Delete(context.Context, database.DeleteAuthorParams) error {
+	return nil
+}
+
+func (permittedService) Purge(context.Context, database.DeleteAuthorParams) error {
+	return nil
+}
+
+func TestRolesConfiguredInConfigYAML(t *testing.T) {
+	policy := auth.NewPolicy(map[string][]string{
+		"reader": {string(PermissionRead)},
+		"editor": {string(PermissionRead), string(PermissionWrite)},
+		"admin":  {string(PermissionRead), string(PermissionWrite), string(PermissionDelete), string(PermissionPurge)},
+		// deleting does not imply purging
+		"moderator": {string(PermissionRead), string(PermissionDelete)},
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(func(c *gin.Context) {
+		principal := &auth.Principal{Subject: "test", Roles: strings.Fields(c.GetHeader("X-Test-Roles"))}
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+	})
+	NewAuthorHandler(permittedService{}, policy).RegisterHandlers(router)
+
+	tests := []struct {
+		method, path, body string
+		allowed            map[string]bool
+	}{
+		{http.MethodGet, "/authors/1", "", map[string]bool{"reader": true, "editor": true, "moderator": true, "admin": true}},
+		{http.MethodPost, "/authors", `{"name":"name","bio":"bio"}`, map[string]bool{"editor": true, "admin": true}},
+		{http.MethodDelete, "/authors/1", "", map[string]bool{"admin": true, "moderator": true}},
+		{http.MethodDelete, "/authors/1?purge=true", "", map[string]bool{"admin": true}},
+	}
+	for _, tt := range tests {
+		for _, role := range []string{"", "reader", "editor", "moderator", "admin"} {
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			request.Header.Set("X-Test-Roles", role)
+			request.Header.Set("If-Match", formatETag(1))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, request)
+
+			name := fmt.Sprintf("%s %s as %q", tt.method, tt.path, role)
+			if tt.allowed[role] {
+				require.Less(t, rec.Code, 300, name)
+			} else {
+				require.Equal(t, http.StatusForbidden, rec.Code, name)
+				require.Equal(t, apperror.ProblemContentType, rec.Header().Get("Content-Type"), name)
+			}
+		}
+	}
+}
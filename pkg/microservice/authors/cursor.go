@@ -0,0 +1,40 @@
+package authors
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+)
+
+const defaultPageLimit = 20
+
+var errInvalidCursor = apperror.Validation("invalid_cursor", "invalid cursor",
+	apperror.FieldError{Field: "cursor", Code: "invalid", Message: "is not a cursor issued for this sort order"})
+
+// cursor is the keyset position of the last author on a page. It is handed
+// to clients as an opaque string so the ordering can change without
+// breaking them.
+type cursor struct {
+	Name string `json:"n"`
+	ID   int64  `json:"i"`
+	// Sort is the canonical sort the cursor was issued for; a cursor is only
+	// valid for the ordering it came from.
+	Sort string `json:"s"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == 0 {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
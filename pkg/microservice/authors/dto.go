@@ -1,9 +1,19 @@
 package authors
 
+import (
+	"encoding/json"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"time"
+)
+
 type Author struct {
 	ID   int64
 	Name string `json:"name,omitempty" binding:"required,max=32"`
 	Bio  string `json:"bio,omitempty" binding:"required"`
+	// Version and UpdatedAt are exposed through the ETag and Last-Modified
+	// headers rather than the body.
+	Version   int64     `json:"-"`
+	UpdatedAt time.Time `json:"-"`
 }
 
 type AuthorPartialUpdate struct {
@@ -14,3 +24,92 @@ type AuthorPartialUpdate struct {
 type PathParameters struct {
 	ID int64 `uri:"id" binding:"required"`
 }
+
+type DeleteParameters struct {
+	// Purge removes the author permanently instead of soft-deleting it, and
+	// requires PermissionPurge.
+	Purge bool `form:"purge"`
+}
+
+type ListParameters struct {
+	Limit       int32  `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor      string `form:"cursor"`
+	Name        string `form:"name" binding:"omitempty,max=32"`
+	NamePrefix  string `form:"name_prefix" binding:"omitempty,max=32"`
+	BioContains string `form:"bio_contains" binding:"omitempty,max=256"`
+	Sort        string `form:"sort" binding:"omitempty,max=64"`
+}
+
+type AuthorPage struct {
+	Items      []*Author `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type SearchParameters struct {
+	Query string `form:"q" binding:"required,max=256"`
+	Limit int32  `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// AuthorSearchResult is an author matching a search query. Headline is an
+// HTML-escaped excerpt of the bio with the matched terms wrapped in <mark>
+// tags.
+type AuthorSearchResult struct {
+	Author
+	Rank     float32 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+type AuthorSearchResults struct {
+	Items []*AuthorSearchResult `json:"items"`
+}
+
+type AuthorAuditEntry struct {
+	ID        int64           `json:"id"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const maxBatchSize = 1000
+
+// maxItemBytes is the room a single author takes up in a request body, a
+// short name with a bio of a few pages.
+const maxItemBytes = 16 << 10
+
+// MaxRequestBytes is the largest request body the author routes accept,
+// enough for a batch of maxBatchSize authors.
+const MaxRequestBytes = maxBatchSize * maxItemBytes
+
+type BatchParameters struct {
+	Mode string `form:"mode" binding:"omitempty,oneof=atomic best_effort"`
+}
+
+type AuthorBatchPatch struct {
+	ID      int64 `json:"id" binding:"required"`
+	Version int64 `json:"version" binding:"required"`
+	AuthorPartialUpdate
+}
+
+type AuthorBatchDelete struct {
+	ID      int64 `json:"id" binding:"required"`
+	Version int64 `json:"version" binding:"required"`
+}
+
+// BatchItemResult reports what happened to the item at Index of a batch
+// request, using the status code the equivalent single-item request would
+// have returned.
+type BatchItemResult struct {
+	Index   int                   `json:"index"`
+	Status  int                   `json:"status"`
+	Author  *Author               `json:"author,omitempty"`
+	Version int64                 `json:"version,omitempty"`
+	Code    string                `json:"code,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	Fields  []apperror.FieldError `json:"errors,omitempty"`
+}
+
+type BatchResponse struct {
+	Items []BatchItemResult `json:"items"`
+}
@@ -0,0 +1,91 @@
+package authors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMissingIfMatch = apperror.PreconditionRequired("if_match_required", "If-Match header is required")
+	errInvalidIfMatch = apperror.Validation("invalid_if_match", "If-Match must be a single strong ETag",
+		apperror.FieldError{Field: "If-Match", Code: "strong_etag", Message: "must be a single strong ETag"})
+)
+
+// formatETag renders an author version as a strong entity tag.
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch extracts the author version from an If-Match header. Only a
+// single strong ETag as issued by formatETag is accepted.
+func parseIfMatch(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, errMissingIfMatch
+	}
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, errInvalidIfMatch
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, errInvalidIfMatch
+	}
+	return version, nil
+}
+
+// pageETag derives a strong entity tag for a list page from the identity and
+// version of every author on it, so any change to the page changes the tag.
+func pageETag(page *AuthorPage) string {
+	h := sha256.New()
+	for _, author := range page.Items {
+		fmt.Fprintf(h, "%d:%d;", author.ID, author.Version)
+	}
+	h.Write([]byte(page.NextCursor))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the validators of a representation on the response and,
+// when the request's If-None-Match or If-Modified-Since shows the client
+// already has it, answers 304 Not Modified and returns true.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence, If-Modified-Since is ignored when present
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if !etagListMatches(inm, etag) {
+			return false
+		}
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// etagListMatches reports whether an If-None-Match header matches etag using
+// the weak comparison GET requires.
+func etagListMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
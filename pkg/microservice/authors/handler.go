@@ -1,44 +1,79 @@
 package authors
 
 import (
-	"database/sql"
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
 	"github.com/potatowhite/restfulapi/pkg/database"
 	"net/http"
+	"reflect"
+	"strconv"
+	"time"
 )
 
 // logger
 type AuthorHandler interface {
-	RegisterHandlers(router *gin.Engine)
+	RegisterHandlers(router gin.IRouter)
 }
 
+// Permissions the author routes require.
+const (
+	PermissionRead   auth.Permission = "authors:read"
+	PermissionWrite  auth.Permission = "authors:write"
+	PermissionDelete auth.Permission = "authors:delete"
+	// PermissionPurge is required on top of PermissionDelete to remove
+	// authors permanently.
+	PermissionPurge auth.Permission = "authors:purge"
+)
+
 type authorHandler struct {
 	service AuthorService
+	policy  *auth.Policy
 }
 
-func NewAuthorHandler(service AuthorService) AuthorHandler {
-	return &authorHandler{service: service}
+// NewAuthorHandler serves the author routes, each allowed to the roles
+// policy grants its permission. A nil policy allows every route.
+func NewAuthorHandler(service AuthorService, policy *auth.Policy) AuthorHandler {
+	return &authorHandler{service: service, policy: policy}
 }
 
-func (h *authorHandler) RegisterHandlers(router *gin.Engine) {
-	router.POST("/authors", h.Create)
-	router.GET("/authors/:id", h.Get)
-	router.PUT("/authors/:id", h.Put)
-	router.PATCH("/authors/:id", h.Patch)
-	router.DELETE("/authors/:id", h.Delete)
-	router.GET("/authors", h.List)
+func (h *authorHandler) RegisterHandlers(router gin.IRouter) {
+	read := h.policy.Require(PermissionRead)
+	write := h.policy.Require(PermissionWrite)
+	remove := h.policy.Require(PermissionDelete)
+	purge := whenPurging(h.policy.Require(PermissionPurge))
+
+	router.POST("/authors", write, h.Create)
+	router.GET("/authors/:id", read, h.Get)
+	router.PUT("/authors/:id", write, h.Put)
+	router.PATCH("/authors/:id", write, h.Patch)
+	router.DELETE("/authors/:id", remove, purge, h.Delete)
+	router.POST("/authors/:id/restore", remove, h.Restore)
+	router.GET("/authors/:id/history", read, h.History)
+	router.GET("/authors", read, h.List)
+	router.GET("/authors/search", read, h.Search)
+	// gin cannot route the literal "/authors:batch", so the suffix after
+	// "/authors" is captured and checked by the handler
+	router.POST("/authors:action", write, h.Batch)
+	router.PATCH("/authors:action", write, h.Batch)
+	router.DELETE("/authors:action", remove, h.Batch)
 }
 
 func (h *authorHandler) Create(c *gin.Context) {
 	var req Author
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
 		return
 	}
 
-	if author, err := h.service.Create(c, database.CreateAuthorParams{Name: req.Name, Bio: req.Bio}); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if author, err := h.service.Create(c.Request.Context(), database.CreateAuthorParams{Name: req.Name, Bio: req.Bio}); err != nil {
+		abort(c, err)
 	} else {
+		c.Header("ETag", formatETag(author.Version))
 		c.JSON(http.StatusCreated, author)
 	}
 }
@@ -46,46 +81,46 @@ func (h *authorHandler) Create(c *gin.Context) {
 func (h *authorHandler) Get(c *gin.Context) {
 	var pathParams PathParameters
 	if err := c.ShouldBindUri(&pathParams); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
 		return
 	}
 
-	author, err := h.service.Get(c, pathParams.ID)
+	author, err := h.service.Get(c.Request.Context(), pathParams.ID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.AbortWithStatus(http.StatusNoContent)
-		} else {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		abort(c, err)
 		return
 	}
 
+	if notModified(c, formatETag(author.Version), author.UpdatedAt) {
+		return
+	}
 	c.JSON(http.StatusOK, author)
 }
 
 func (h *authorHandler) Put(c *gin.Context) {
 	var pathParams PathParameters
 	if err := c.ShouldBindUri(&pathParams); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
 	var req Author
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
 		return
 	}
 
-	author, err := h.service.Put(c, database.UpdateAuthorParams{ID: pathParams.ID, Name: req.Name, Bio: req.Bio})
+	author, err := h.service.Put(c.Request.Context(), database.UpdateAuthorParams{ID: pathParams.ID, Name: req.Name, Bio: req.Bio, Version: version})
 	if err != nil {
-		// no row
-		if err == sql.ErrNoRows {
-			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
-		} else {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		abort(c, err)
 		return
 	} else {
+		c.Header("ETag", formatETag(author.Version))
 		c.JSON(http.StatusOK, author)
 	}
 }
@@ -93,18 +128,22 @@ func (h *authorHandler) Put(c *gin.Context) {
 func (h *authorHandler) Patch(c *gin.Context) {
 	var pathParam PathParameters
 	if err := c.ShouldBindUri(&pathParam); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	// print the request body
 	var req AuthorPartialUpdate
 	if err := c.ShouldBindJSON(&req); err != nil {
 
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
 		return
 	}
-	params := database.PartialUpdateAuthorParams{ID: pathParam.ID}
+	params := database.PartialUpdateAuthorParams{ID: pathParam.ID, Version: version}
 	if req.Name != nil {
 		params.Name = *req.Name
 		params.UpdateName = true
@@ -114,39 +153,343 @@ func (h *authorHandler) Patch(c *gin.Context) {
 		params.UpdateBio = true
 	}
 
-	author, err := h.service.Patch(c, params)
+	author, err := h.service.Patch(c.Request.Context(), params)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to update author"})
+		abort(c, err)
 		return
 	}
 
+	c.Header("ETag", formatETag(author.Version))
 	c.JSON(http.StatusOK, author)
 }
 
 func (h *authorHandler) Delete(c *gin.Context) {
 	var pathParams PathParameters
 	if err := c.ShouldBindUri(&pathParams); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	var query DeleteParameters
+	if err := c.ShouldBindQuery(&query); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	if err := h.service.Delete(c, pathParams.ID); err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	cmd := database.DeleteAuthorParams{ID: pathParams.ID, Version: version}
+	remove := h.service.Delete
+	if query.Purge {
+		remove = h.service.Purge
+	}
+	if err := remove(c.Request.Context(), cmd); err != nil {
+		abort(c, err)
 		return
 	}
 
 	c.Status(http.StatusNoContent)
 }
 
+func (h *authorHandler) Restore(c *gin.Context) {
+	var pathParams PathParameters
+	if err := c.ShouldBindUri(&pathParams); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	author, err := h.service.Restore(c.Request.Context(), pathParams.ID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.Header("ETag", formatETag(author.Version))
+	c.JSON(http.StatusOK, author)
+}
+
+func (h *authorHandler) History(c *gin.Context) {
+	var pathParams PathParameters
+	if err := c.ShouldBindUri(&pathParams); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	history, err := h.service.History(c.Request.Context(), pathParams.ID)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if len(history) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
 func (h *authorHandler) List(c *gin.Context) {
-	authors, err := h.service.List(c)
+	var query ListParameters
+	if err := c.ShouldBindQuery(&query); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	sorts, err := parseSort(query.Sort)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	params := database.ListAuthorsParams{
+		Name:        query.Name,
+		NamePrefix:  query.NamePrefix,
+		BioContains: query.BioContains,
+		Sort:        sorts,
+		Limit:       defaultPageLimit,
+	}
+	if query.Limit != 0 {
+		params.Limit = query.Limit
+	}
+	if query.Cursor != "" {
+		cur, err := decodeCursor(query.Cursor)
+		if err != nil || cur.Sort != formatSort(sorts) {
+			abort(c, errInvalidCursor)
+			return
+		}
+		params.After = &database.Author{ID: cur.ID, Name: cur.Name}
+	}
+
+	page, err := h.service.List(c.Request.Context(), params)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abort(c, err)
 		return
 	}
-	if len(authors) == 0 {
+	if len(page.Items) == 0 {
 		c.Status(http.StatusNoContent)
 		return
 	}
-	c.JSON(http.StatusOK, authors)
+
+	// pages are revalidated by ETag only: a Last-Modified taken from the
+	// authors on the page cannot tell when one of them left it
+	if notModified(c, pageETag(page), time.Time{}) {
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *authorHandler) Search(c *gin.Context) {
+	var query SearchParameters
+	if err := c.ShouldBindQuery(&query); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+
+	params := database.SearchAuthorsParams{Query: query.Query, PageLimit: defaultPageLimit}
+	if query.Limit != 0 {
+		params.PageLimit = query.Limit
+	}
+
+	results, err := h.service.Search(c.Request.Context(), params)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+	if len(results.Items) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// ifMatchVersion reads the expected author version from If-Match, aborting
+// the request when the header is missing or malformed.
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+	version, err := parseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		abort(c, err)
+		return 0, false
+	}
+	return version, true
+}
+
+// whenPurging applies require only to the requests asking for a purge.
+func whenPurging(require gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if purge, _ := strconv.ParseBool(c.Query("purge")); purge {
+			require(c)
+		}
+	}
+}
+
+// abort stops the request with err, which apperror.Middleware renders as a
+// problem response.
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// Batch handles POST, PATCH and DELETE /authors:batch. Each takes a JSON array
+// of items and reports a result per item. In the default atomic mode nothing
+// is applied unless every item is valid and succeeds; with mode=best_effort
+// every valid item that succeeds is applied.
+func (h *authorHandler) Batch(c *gin.Context) {
+	if c.Param("action") != ":batch" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var query BatchParameters
+	if err := c.ShouldBindQuery(&query); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return
+	}
+	mode := BatchAtomic
+	if query.Mode != "" {
+		mode = BatchMode(query.Mode)
+	}
+
+	switch c.Request.Method {
+	case http.MethodPost:
+		var items []Author
+		if results, ok := bindBatch(c, &items, mode); ok {
+			cmds := make([]database.CreateAuthorParams, 0, len(items))
+			for _, i := range results.valid {
+				cmds = append(cmds, database.CreateAuthorParams{Name: items[i].Name, Bio: items[i].Bio})
+			}
+			outcomes, err := h.service.CreateBatch(c.Request.Context(), cmds, mode)
+			results.respond(c, outcomes, err, http.StatusCreated)
+		}
+	case http.MethodPatch:
+		var items []AuthorBatchPatch
+		if results, ok := bindBatch(c, &items, mode); ok {
+			cmds := make([]database.PartialUpdateAuthorParams, 0, len(items))
+			for _, i := range results.valid {
+				params := database.PartialUpdateAuthorParams{ID: items[i].ID, Version: items[i].Version}
+				if items[i].Name != nil {
+					params.Name = *items[i].Name
+					params.UpdateName = true
+				}
+				if items[i].Bio != nil {
+					params.Bio = *items[i].Bio
+					params.UpdateBio = true
+				}
+				cmds = append(cmds, params)
+			}
+			outcomes, err := h.service.PatchBatch(c.Request.Context(), cmds, mode)
+			results.respond(c, outcomes, err, http.StatusOK)
+		}
+	case http.MethodDelete:
+		var items []AuthorBatchDelete
+		if results, ok := bindBatch(c, &items, mode); ok {
+			cmds := make([]database.DeleteAuthorParams, 0, len(items))
+			for _, i := range results.valid {
+				cmds = append(cmds, database.DeleteAuthorParams{ID: items[i].ID, Version: items[i].Version})
+			}
+			outcomes, err := h.service.DeleteBatch(c.Request.Context(), cmds, mode)
+			results.respond(c, outcomes, err, http.StatusNoContent)
+		}
+	}
+}
+
+// batchResults collects the per-item results of a batch request while it is
+// validated and executed.
+type batchResults struct {
+	mode  BatchMode
+	items []BatchItemResult
+	// valid holds the indexes of the items that passed validation, in the
+	// order they are handed to the service.
+	valid []int
+}
+
+// bindBatch decodes a batch into items, which must point to a slice, and
+// decodes and validates every item on its own, rejecting unknown fields like
+// the single-item routes do. It aborts the request and returns false when
+// the body is unusable, or when an atomic batch has invalid items.
+func bindBatch(c *gin.Context, items interface{}, mode BatchMode) (*batchResults, bool) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&raw); err != nil {
+		abort(c, apperror.FromBinding(err))
+		return nil, false
+	}
+	if len(raw) == 0 || len(raw) > maxBatchSize {
+		abort(c, apperror.Validation("invalid_batch_size", fmt.Sprintf("a batch must have between 1 and %d items", maxBatchSize)))
+		return nil, false
+	}
+	slice := reflect.ValueOf(items).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), len(raw), len(raw)))
+
+	results := &batchResults{mode: mode, items: make([]BatchItemResult, len(raw))}
+	for i := range results.items {
+		results.items[i].Index = i
+		if err := bindBatchItem(raw[i], slice.Index(i).Addr().Interface()); err != nil {
+			results.items[i].setError(apperror.FromBinding(err))
+		} else {
+			results.valid = append(results.valid, i)
+		}
+	}
+
+	if mode == BatchAtomic && len(results.valid) < len(results.items) {
+		for _, i := range results.valid {
+			results.items[i].setError(ErrNotApplied)
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, BatchResponse{Items: results.items})
+		return nil, false
+	}
+	return results, true
+}
+
+func bindBatchItem(raw json.RawMessage, item interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(item); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(item)
+}
+
+// respond merges the service outcomes of the valid items into the results
+// and writes them. An atomic batch answers with the status of the failing
+// item, or with successStatus when every item succeeded; a best-effort batch
+// always answers 207 Multi-Status.
+func (r *batchResults) respond(c *gin.Context, outcomes []BatchOutcome, err error, successStatus int) {
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	status := successStatus
+	if successStatus == http.StatusNoContent {
+		// the per-item results still need a body
+		status = http.StatusOK
+	}
+	for n, i := range r.valid {
+		item := &r.items[i]
+		if outcome := outcomes[n]; outcome.Err != nil {
+			item.setError(outcome.Err)
+			if outcome.Err != ErrNotApplied {
+				status = item.Status
+			}
+		} else {
+			item.Status = successStatus
+			item.Author = outcome.Author
+			if outcome.Author != nil {
+				item.Version = outcome.Author.Version
+			}
+		}
+	}
+
+	if r.mode == BatchBestEffort {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, BatchResponse{Items: r.items})
+}
+
+func (r *BatchItemResult) setError(err error) {
+	problem := apperror.NewProblem(err, "")
+	r.Status = problem.Status
+	r.Code = problem.Code
+	r.Error = problem.Detail
+	r.Fields = problem.Errors
 }
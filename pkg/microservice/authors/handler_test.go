@@ -3,26 +3,30 @@ package authors
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/potatowhite/restfulapi/cmd/config"
+	"github.com/potatowhite/restfulapi/pkg/actor"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
 	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/potatowhite/restfulapi/pkg/openapi"
 	"github.com/stretchr/testify/suite"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
-type apiError struct {
-	Error string
-}
-
 type ServiceTestSuite struct {
 	suite.Suite
 	router  *gin.Engine
+	db      *sql.DB
 	queries *database.Queries
+	service AuthorService
 }
 
 func TestServiceTestSuite(t *testing.T) {
@@ -33,14 +37,33 @@ func (s *ServiceTestSuite) SetupSuite() {
 	cfg, err := config.Read()
 	s.Require().NoError(err)
 
-	postgres, err := database.NewPostgres(cfg.Database.Host, cfg.Database.Port, cfg.Database.Username, cfg.Database.Password, cfg.Database.Dbname)
+	postgres, err := database.NewPostgres(database.Options{URL: string(cfg.Database.URL), Host: cfg.Database.Host, Port: cfg.Database.Port, User: cfg.Database.Username, Password: string(cfg.Database.Password), Dbname: cfg.Database.Dbname, SSLMode: cfg.Database.SSLMode})
+	s.Require().NoError(err)
+
+	migrator, err := database.NewMigrator(postgres.DB)
 	s.Require().NoError(err)
+	s.Require().NoError(migrator.Up(context.Background()))
 
-	s.queries = database.New(postgres.DB)
-	service := NewAuthorService(s.queries)
-	handler := NewAuthorHandler(service)
+	s.db = postgres.DB
+	txManager := database.NewTxManager(postgres.DB)
+	s.queries = txManager.Queries()
+	s.service = NewAuthorService(txManager)
+	handler := NewAuthorHandler(s.service, nil)
+
+	spec, err := openapi.Parse(Spec)
+	s.Require().NoError(err)
+	validator := openapi.NewValidator(spec, openapi.ValidatorOptions{MaxBodyBytes: MaxRequestBytes})
 
 	s.router = gin.Default()
+	s.router.Use(validator.Responses(func(c *gin.Context, err error) {
+		s.T().Errorf("response does not match the API description: %s", err)
+	}))
+	s.router.Use(apperror.Middleware())
+	s.router.Use(validator.Requests())
+	// stands in for authentication until there is some
+	s.router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(actor.With(c.Request.Context(), c.GetHeader("X-Test-Actor")))
+	})
 	handler.RegisterHandlers(s.router)
 }
 
@@ -61,6 +84,7 @@ func (s *ServiceTestSuite) TestCreateAuthor() {
 	// Act
 	request, err := http.NewRequest(http.MethodPost, "/authors", &buffer)
 	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -90,6 +114,7 @@ func (s *ServiceTestSuite) TestCreateAuthor_InvalidRequest() {
 	// Act
 	request, err := http.NewRequest(http.MethodPost, "/authors", &buffer)
 	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -98,12 +123,10 @@ func (s *ServiceTestSuite) TestCreateAuthor_InvalidRequest() {
 	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
 
 	// Assert Response Body
-	var apiErr apiError
-	if err := json.NewDecoder(rec.Result().Body).Decode(&apiErr); err != nil {
-		log.Printf("Error decoding rec body: %v", err)
-	}
-
-	s.Require().Equal("Key: 'Author.Bio' Error:Field validation for 'Bio' failed on the 'required' tag", apiErr.Error)
+	problem := s.decodeProblem(rec)
+	s.Require().Equal("invalid_request", problem.Code)
+	s.Require().Equal(http.StatusBadRequest, problem.Status)
+	s.Require().Equal([]apperror.FieldError{{Field: "bio", Code: "required", Message: "is required"}}, problem.Errors)
 }
 
 func (s *ServiceTestSuite) TestGetAuthor() {
@@ -137,6 +160,7 @@ func (s *ServiceTestSuite) TestGetAuthor() {
 
 	s.Require().Equal(author.Name, got.Name)
 	s.Require().Equal(author.Bio, got.Bio)
+	s.Require().Equal(formatETag(created.Version), rec.Header().Get("ETag"))
 }
 
 func (s *ServiceTestSuite) TestGetAuthor_NotFound() {
@@ -148,13 +172,12 @@ func (s *ServiceTestSuite) TestGetAuthor_NotFound() {
 	s.router.ServeHTTP(rec, request)
 
 	// Assert Status Code
-	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusNotFound, rec.Result().StatusCode)
 
 	// Assert Response Body
-	var apiErr apiError
-	if err := json.NewDecoder(rec.Result().Body).Decode(&apiErr); err != nil {
-		log.Printf("Error decoding rec body: %v", err)
-	}
+	problem := s.decodeProblem(rec)
+	s.Require().Equal("author_not_found", problem.Code)
+	s.Require().Equal("/authors/1", problem.Instance)
 }
 
 func (s *ServiceTestSuite) TestListAuthors() {
@@ -192,16 +215,90 @@ func (s *ServiceTestSuite) TestListAuthors() {
 	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
 
 	// Assert Response Body
-	var got []Author
+	var got AuthorPage
 	if err := json.NewDecoder(rec.Result().Body).Decode(&got); err != nil {
 		log.Printf("Error decoding rec body: %v", err)
 	}
 
-	s.Require().Len(got, 2)
-	s.Require().Equal(author1.Name, got[0].Name)
-	s.Require().Equal(author1.Bio, got[0].Bio)
-	s.Require().Equal(author2.Name, got[1].Name)
-	s.Require().Equal(author2.Bio, got[1].Bio)
+	s.Require().Len(got.Items, 2)
+	s.Require().Equal(author1.Name, got.Items[0].Name)
+	s.Require().Equal(author1.Bio, got.Items[0].Bio)
+	s.Require().Equal(author2.Name, got.Items[1].Name)
+	s.Require().Equal(author2.Bio, got.Items[1].Bio)
+	s.Require().Empty(got.NextCursor)
+}
+
+func (s *ServiceTestSuite) TestListAuthors_Paginated() {
+	// Arrange
+	for _, name := range []string{"c", "a", "b"} {
+		_, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+			Name: name,
+			Bio:  "test bio",
+		})
+		s.Require().NoError(err)
+	}
+
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors?limit=2", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert First Page
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	var first AuthorPage
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&first))
+	s.Require().Len(first.Items, 2)
+	s.Require().Equal("a", first.Items[0].Name)
+	s.Require().Equal("b", first.Items[1].Name)
+	s.Require().NotEmpty(first.NextCursor)
+
+	// Act
+	request, err = http.NewRequest(http.MethodGet, "/authors?limit=2&cursor="+first.NextCursor, nil)
+	s.Require().NoError(err)
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Second Page
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	var second AuthorPage
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&second))
+	s.Require().Len(second.Items, 1)
+	s.Require().Equal("c", second.Items[0].Name)
+	s.Require().Empty(second.NextCursor)
+}
+
+func (s *ServiceTestSuite) TestListAuthors_InvalidCursor() {
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors?cursor=not-a-cursor", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
+
+	// Assert Response Body
+	problem := s.decodeProblem(rec)
+	s.Require().Equal("invalid_cursor", problem.Code)
+	s.Require().Equal("cursor", problem.Errors[0].Field)
+}
+
+func (s *ServiceTestSuite) TestListAuthors_InvalidLimit() {
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors?limit=1000", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
 }
 
 func (s *ServiceTestSuite) TestListAuthors_Empty() {
@@ -216,12 +313,156 @@ func (s *ServiceTestSuite) TestListAuthors_Empty() {
 	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
 
 	// Assert Response Body
-	var got []Author
+	var got AuthorPage
 	if err := json.NewDecoder(rec.Result().Body).Decode(&got); err != nil {
 		log.Printf("Error decoding rec body: %v", err)
 	}
 
-	s.Require().Len(got, 0)
+	s.Require().Len(got.Items, 0)
+}
+
+func (s *ServiceTestSuite) TestListAuthors_FilterAndSort() {
+	// Arrange
+	for _, author := range []Author{
+		{Name: "alice", Bio: "writes poetry"},
+		{Name: "albert", Bio: "writes novels"},
+		{Name: "bob", Bio: "writes POETRY too"},
+	} {
+		_, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+			Name: author.Name,
+			Bio:  author.Bio,
+		})
+		s.Require().NoError(err)
+	}
+
+	for _, tc := range []struct {
+		query string
+		names []string
+	}{
+		{query: "name=bob", names: []string{"bob"}},
+		{query: "name_prefix=al", names: []string{"albert", "alice"}},
+		{query: "bio_contains=poetry", names: []string{"alice", "bob"}},
+		{query: "name_prefix=al&sort=-name", names: []string{"alice", "albert"}},
+		{query: "sort=-name&limit=2", names: []string{"bob", "alice"}},
+	} {
+		// Act
+		request, err := http.NewRequest(http.MethodGet, "/authors?"+tc.query, nil)
+		s.Require().NoError(err)
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+
+		// Assert
+		s.Require().Equal(http.StatusOK, rec.Result().StatusCode, tc.query)
+
+		var got AuthorPage
+		s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&got))
+
+		var names []string
+		for _, item := range got.Items {
+			names = append(names, item.Name)
+		}
+		s.Require().Equal(tc.names, names, tc.query)
+	}
+}
+
+func (s *ServiceTestSuite) TestListAuthors_InvalidSort() {
+	for _, sort := range []string{"bio", "name,-name", "name%3Bdrop"} {
+		// Act
+		request, err := http.NewRequest(http.MethodGet, "/authors?sort="+sort, nil)
+		s.Require().NoError(err)
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+
+		// Assert Status Code
+		s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode, sort)
+	}
+}
+
+func (s *ServiceTestSuite) TestSearchAuthors() {
+	// Arrange
+	for _, author := range []Author{
+		{Name: "poet", Bio: "writes poems about the sea, and the sea again"},
+		{Name: "novelist", Bio: "writes long novels about the sea"},
+		{Name: "chef", Bio: "cooks pasta"},
+	} {
+		_, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+			Name: author.Name,
+			Bio:  author.Bio,
+		})
+		s.Require().NoError(err)
+	}
+
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors/search?q=sea", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	// Assert Response Body
+	var got AuthorSearchResults
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&got))
+	s.Require().Len(got.Items, 2)
+	s.Require().Equal("poet", got.Items[0].Name)
+	s.Require().Equal("novelist", got.Items[1].Name)
+	s.Require().GreaterOrEqual(got.Items[0].Rank, got.Items[1].Rank)
+	s.Require().Contains(got.Items[0].Headline, "<mark>sea</mark>")
+}
+
+func (s *ServiceTestSuite) TestSearchAuthors_EscapesTheBio() {
+	// Arrange
+	_, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "mallory",
+		Bio:  "<script>alert(1)</script> by the sea \x03",
+	})
+	s.Require().NoError(err)
+
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors/search?q=sea", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	var got AuthorSearchResults
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&got))
+	s.Require().Len(got.Items, 1)
+	s.Require().Contains(got.Items[0].Headline, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	s.Require().Contains(got.Items[0].Headline, "<mark>sea</mark>")
+	// the bio cannot close a mark of its own
+	s.Require().NotContains(got.Items[0].Headline, "\x03")
+	s.Require().Equal(1, strings.Count(got.Items[0].Headline, "</mark>"))
+}
+
+func (s *ServiceTestSuite) TestSearchAuthors_NoMatch() {
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors/search?q=dragons", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+}
+
+func (s *ServiceTestSuite) TestSearchAuthors_MissingQuery() {
+	// Act
+	request, err := http.NewRequest(http.MethodGet, "/authors/search", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
 }
 
 func (s *ServiceTestSuite) TestUpdateAuthor() {
@@ -246,6 +487,8 @@ func (s *ServiceTestSuite) TestUpdateAuthor() {
 	// Act
 	request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/authors/%d", created.ID), &buffer)
 	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("If-Match", formatETag(created.Version))
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -261,6 +504,7 @@ func (s *ServiceTestSuite) TestUpdateAuthor() {
 
 	s.Require().Equal(author.Name, updated.Name)
 	s.Require().Equal(author.Bio, updated.Bio)
+	s.Require().Equal(formatETag(created.Version+1), rec.Header().Get("ETag"))
 }
 
 func (s *ServiceTestSuite) TestUpdateAuthor_NotFound() {
@@ -276,6 +520,8 @@ func (s *ServiceTestSuite) TestUpdateAuthor_NotFound() {
 	// Act
 	request, err := http.NewRequest(http.MethodPut, "/authors/1", &buffer)
 	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("If-Match", formatETag(1))
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -284,10 +530,7 @@ func (s *ServiceTestSuite) TestUpdateAuthor_NotFound() {
 	s.Require().Equal(http.StatusNotFound, rec.Result().StatusCode)
 
 	// Assert Response Body
-	var apiErr apiError
-	if err := json.NewDecoder(rec.Result().Body).Decode(&apiErr); err != nil {
-		log.Printf("Error decoding rec body: %v", err)
-	}
+	s.Require().Equal("author_not_found", s.decodeProblem(rec).Code)
 }
 
 func (s *ServiceTestSuite) TestPartialUpdateAuthor() {
@@ -311,6 +554,8 @@ func (s *ServiceTestSuite) TestPartialUpdateAuthor() {
 	// Act
 	request, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/authors/%d", created.ID), &buffer)
 	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("If-Match", formatETag(created.Version))
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -344,6 +589,7 @@ func (s *ServiceTestSuite) TestDeleteAuthor() {
 	// Act
 	request, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/authors/%d", created.ID), nil)
 	s.Require().NoError(err)
+	request.Header.Set("If-Match", formatETag(created.Version))
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
@@ -357,10 +603,568 @@ func (s *ServiceTestSuite) TestDeleteAuthor_NotFound() {
 	// Act
 	request, err := http.NewRequest(http.MethodDelete, "/authors/1", nil)
 	s.Require().NoError(err)
+	request.Header.Set("If-Match", formatETag(1))
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert Status Code
+	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+}
+
+func (s *ServiceTestSuite) TestUpdateAuthor_StaleVersion() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	_, err = s.queries.UpdateAuthor(context.Background(), database.UpdateAuthorParams{
+		ID:      created.ID,
+		Name:    "other editor",
+		Bio:     "other bio",
+		Version: created.Version,
+	})
+	s.Require().NoError(err)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		var buffer bytes.Buffer
+		s.Require().NoError(json.NewEncoder(&buffer).Encode(Author{Name: "my name", Bio: "my bio"}))
+
+		// Act
+		request, err := http.NewRequest(method, fmt.Sprintf("/authors/%d", created.ID), &buffer)
+		s.Require().NoError(err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("If-Match", formatETag(created.Version))
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+
+		// Assert Status Code
+		s.Require().Equal(http.StatusPreconditionFailed, rec.Result().StatusCode, method)
+	}
+
+	got, err := s.queries.GetAuthor(context.Background(), created.ID)
+	s.Require().NoError(err)
+	s.Require().Equal("other editor", got.Name)
+}
+
+func (s *ServiceTestSuite) TestUpdateAuthor_MissingIfMatch() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		var buffer bytes.Buffer
+		s.Require().NoError(json.NewEncoder(&buffer).Encode(Author{Name: "my name", Bio: "my bio"}))
+
+		// Act
+		request, err := http.NewRequest(method, fmt.Sprintf("/authors/%d", created.ID), &buffer)
+		s.Require().NoError(err)
+		request.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+
+		// Assert Status Code
+		s.Require().Equal(http.StatusPreconditionRequired, rec.Result().StatusCode, method)
+	}
+}
+
+func (s *ServiceTestSuite) TestGetAuthor_NotModified() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	for name, header := range map[string][2]string{
+		"etag":           {"If-None-Match", formatETag(created.Version)},
+		"etag list":      {"If-None-Match", `"999", W/` + formatETag(created.Version)},
+		"modified since": {"If-Modified-Since", created.UpdatedAt.Add(time.Second).UTC().Format(http.TimeFormat)},
+	} {
+		// Act
+		request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/authors/%d", created.ID), nil)
+		s.Require().NoError(err)
+		request.Header.Set(header[0], header[1])
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+
+		// Assert
+		s.Require().Equal(http.StatusNotModified, rec.Result().StatusCode, name)
+		s.Require().Empty(rec.Body.Bytes(), name)
+		s.Require().Equal(formatETag(created.Version), rec.Header().Get("ETag"), name)
+	}
+}
+
+func (s *ServiceTestSuite) TestGetAuthor_Modified() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	updated, err := s.queries.UpdateAuthor(context.Background(), database.UpdateAuthorParams{
+		ID:      created.ID,
+		Name:    "updated name",
+		Bio:     "updated bio",
+		Version: created.Version,
+	})
+	s.Require().NoError(err)
+	s.Require().False(updated.UpdatedAt.Before(created.UpdatedAt))
+
+	// Act
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/authors/%d", created.ID), nil)
+	s.Require().NoError(err)
+	request.Header.Set("If-None-Match", formatETag(created.Version))
+	request.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert: If-None-Match wins over If-Modified-Since
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	s.Require().Equal(formatETag(updated.Version), rec.Header().Get("ETag"))
+	s.Require().NotEmpty(rec.Header().Get("Last-Modified"))
+}
+
+func (s *ServiceTestSuite) TestListAuthors_NotModified() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	request, err := http.NewRequest(http.MethodGet, "/authors", nil)
+	s.Require().NoError(err)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	etag := rec.Header().Get("ETag")
+	s.Require().NotEmpty(etag)
+	s.Require().Empty(rec.Header().Get("Last-Modified"))
+
+	// Act: If-Modified-Since cannot validate a page
+	request.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	request.Header.Del("If-Modified-Since")
+
+	// Act: unchanged list
+	request.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert
+	s.Require().Equal(http.StatusNotModified, rec.Result().StatusCode)
+	s.Require().Empty(rec.Body.Bytes())
+
+	// Act: list changed
+	_, err = s.queries.UpdateAuthor(context.Background(), database.UpdateAuthorParams{
+		ID:      created.ID,
+		Name:    "updated name",
+		Bio:     "updated bio",
+		Version: created.Version,
+	})
+	s.Require().NoError(err)
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	s.Require().NotEqual(etag, rec.Header().Get("ETag"))
+}
+
+func (s *ServiceTestSuite) TestDeleteAuthor_SoftDeleteAndRestore() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	request, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/authors/%d", created.ID), nil)
+	s.Require().NoError(err)
+	request.Header.Set("If-Match", formatETag(created.Version))
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+
+	// Assert: hidden from reads, but still stored
+	_, err = s.queries.GetAuthor(context.Background(), created.ID)
+	s.Require().ErrorIs(err, sql.ErrNoRows)
+
+	request, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/authors/%d", created.ID), nil)
+	s.Require().NoError(err)
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	s.Require().Equal(http.StatusNotFound, rec.Result().StatusCode)
+
+	for _, path := range []string{"/authors", "/authors/search?q=bio"} {
+		request, err = http.NewRequest(http.MethodGet, path, nil)
+		s.Require().NoError(err)
+		rec = httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+		s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode, path)
+	}
+
+	// Act: restore
+	request, err = http.NewRequest(http.MethodPost, fmt.Sprintf("/authors/%d/restore", created.ID), nil)
+	s.Require().NoError(err)
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	// Assert
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	var restored Author
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&restored))
+	s.Require().Equal(created.Name, restored.Name)
+
+	_, err = s.queries.GetAuthor(context.Background(), created.ID)
+	s.Require().NoError(err)
+}
+
+func (s *ServiceTestSuite) TestRestoreAuthor_NotDeleted() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	// Act
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("/authors/%d/restore", created.ID), nil)
+	s.Require().NoError(err)
 
 	rec := httptest.NewRecorder()
 	s.router.ServeHTTP(rec, request)
 
 	// Assert Status Code
+	s.Require().Equal(http.StatusConflict, rec.Result().StatusCode)
+	var problem apperror.Problem
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&problem))
+	s.Require().Equal(ErrAuthorNotDeleted.Code, problem.Code)
+}
+
+func (s *ServiceTestSuite) TestDeleteAuthor_Purge() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	purge := func(ifMatch string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/authors/%d?purge=true", created.ID), nil)
+		s.Require().NoError(err)
+		request.Header.Set("If-Match", ifMatch)
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	// Act: stale version, nothing is purged
+	rec := purge(formatETag(created.Version + 1))
+
+	// Assert
+	s.Require().Equal(http.StatusPreconditionFailed, rec.Result().StatusCode)
+	_, err = s.queries.GetAuthor(context.Background(), created.ID)
+	s.Require().NoError(err)
+
+	// Act
+	rec = purge(formatETag(created.Version))
+
+	// Assert
+	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+
+	_, err = s.queries.RestoreAuthor(context.Background(), created.ID)
+	s.Require().ErrorIs(err, sql.ErrNoRows)
+}
+
+func (s *ServiceTestSuite) TestAuthorHistory() {
+	// Arrange
+	send := func(method string, path string, body interface{}, ifMatch string) *httptest.ResponseRecorder {
+		var buffer bytes.Buffer
+		if body != nil {
+			s.Require().NoError(json.NewEncoder(&buffer).Encode(body))
+		}
+		request, err := http.NewRequest(method, path, &buffer)
+		s.Require().NoError(err)
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Test-Actor", "alice")
+		if ifMatch != "" {
+			request.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/authors", Author{Name: "test name", Bio: "test bio"}, "")
+	s.Require().Equal(http.StatusCreated, rec.Result().StatusCode)
+	var created Author
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&created))
+	path := fmt.Sprintf("/authors/%d", created.ID)
+
+	rec = send(http.MethodPut, path, Author{Name: "updated name", Bio: "updated bio"}, rec.Header().Get("ETag"))
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	rec = send(http.MethodDelete, path, nil, rec.Header().Get("ETag"))
 	s.Require().Equal(http.StatusNoContent, rec.Result().StatusCode)
+
+	// Act
+	rec = send(http.MethodGet, path+"/history", nil, "")
+
+	// Assert
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+
+	var history []AuthorAuditEntry
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&history))
+	s.Require().Len(history, 3)
+
+	s.Require().Equal(auditCreate, history[0].Action)
+	s.Require().Equal(auditUpdate, history[1].Action)
+	s.Require().Equal(auditDelete, history[2].Action)
+	for _, entry := range history {
+		s.Require().Equal("alice", entry.Actor)
+	}
+
+	s.Require().JSONEq("null", string(history[0].Before))
+	var before, after authorSnapshot
+	s.Require().NoError(json.Unmarshal(history[1].Before, &before))
+	s.Require().NoError(json.Unmarshal(history[1].After, &after))
+	s.Require().Equal("test name", before.Name)
+	s.Require().Equal("updated name", after.Name)
+
+	s.Require().NoError(json.Unmarshal(history[2].After, &after))
+	s.Require().NotNil(after.DeletedAt)
+}
+
+func (s *ServiceTestSuite) TestAuthorHistory_RolledBackWithMutation() {
+	// Arrange
+	created, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{
+		Name: "test name",
+		Bio:  "test bio",
+	})
+	s.Require().NoError(err)
+
+	var buffer bytes.Buffer
+	s.Require().NoError(json.NewEncoder(&buffer).Encode(Author{Name: "updated name", Bio: "updated bio"}))
+
+	// Act: stale version, nothing changes
+	request, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/authors/%d", created.ID), &buffer)
+	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("If-Match", formatETag(created.Version+1))
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+	s.Require().Equal(http.StatusPreconditionFailed, rec.Result().StatusCode)
+
+	// Assert
+	history, err := s.queries.ListAuthorAudit(context.Background(), created.ID)
+	s.Require().NoError(err)
+	s.Require().Empty(history)
+}
+
+func (s *ServiceTestSuite) sendBatch(method string, path string, items interface{}) (*httptest.ResponseRecorder, BatchResponse) {
+	var buffer bytes.Buffer
+	s.Require().NoError(json.NewEncoder(&buffer).Encode(items))
+
+	request, err := http.NewRequest(method, path, &buffer)
+	s.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, request)
+
+	var response BatchResponse
+	if err := json.NewDecoder(rec.Result().Body).Decode(&response); err != nil {
+		log.Printf("Error decoding rec body: %v", err)
+	}
+	return rec, response
+}
+
+func (s *ServiceTestSuite) countAuthors() int {
+	var count int
+	s.Require().NoError(s.db.QueryRow("SELECT count(*) FROM authors").Scan(&count))
+	return count
+}
+
+func (s *ServiceTestSuite) TestBatchCreate() {
+	// Act
+	rec, response := s.sendBatch(http.MethodPost, "/authors:batch", []Author{
+		{Name: "first", Bio: "first bio"},
+		{Name: "second", Bio: "second bio"},
+	})
+
+	// Assert
+	s.Require().Equal(http.StatusCreated, rec.Result().StatusCode)
+	s.Require().Len(response.Items, 2)
+	for i, name := range []string{"first", "second"} {
+		s.Require().Equal(i, response.Items[i].Index)
+		s.Require().Equal(http.StatusCreated, response.Items[i].Status)
+		s.Require().Equal(name, response.Items[i].Author.Name)
+		s.Require().Equal(int64(1), response.Items[i].Version)
+	}
+	s.Require().Equal(2, s.countAuthors())
+}
+
+func (s *ServiceTestSuite) TestBatchCreate_AtomicRejectsInvalidItems() {
+	// Act
+	rec, response := s.sendBatch(http.MethodPost, "/authors:batch", []Author{
+		{Name: "valid", Bio: "valid bio"},
+		{Name: "missing bio"},
+	})
+
+	// Assert
+	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusFailedDependency, response.Items[0].Status)
+	s.Require().Equal(http.StatusBadRequest, response.Items[1].Status)
+	s.Require().Equal(0, s.countAuthors())
+}
+
+func (s *ServiceTestSuite) TestBatchCreate_AtomicReportsDatabaseFailuresPerItem() {
+	// Act: too long for the column, which only the database notices here
+	outcomes, err := s.service.CreateBatch(context.Background(), []database.CreateAuthorParams{
+		{Name: "valid", Bio: "valid bio"},
+		{Name: strings.Repeat("x", 33), Bio: "bio"},
+		{Name: "also valid", Bio: "bio"},
+	}, BatchAtomic)
+
+	// Assert
+	s.Require().NoError(err)
+	s.Require().Len(outcomes, 3)
+	s.Require().ErrorIs(outcomes[0].Err, ErrNotApplied)
+	s.Require().Equal(database.ErrValueTooLong.Code, apperror.As(outcomes[1].Err).Code)
+	s.Require().ErrorIs(outcomes[2].Err, ErrNotApplied)
+	s.Require().Equal(0, s.countAuthors())
+}
+
+func (s *ServiceTestSuite) TestBatchCreate_RejectsUnknownFieldsPerItem() {
+	// Act
+	rec, response := s.sendBatch(http.MethodPost, "/authors:batch?mode=best_effort", []map[string]string{
+		{"name": "valid", "bio": "valid bio"},
+		{"name": "typo", "bio": "bio", "biography": "bio"},
+	})
+
+	// Assert
+	s.Require().Equal(http.StatusMultiStatus, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusCreated, response.Items[0].Status)
+	s.Require().Equal(http.StatusBadRequest, response.Items[1].Status)
+	s.Require().Equal([]apperror.FieldError{{Field: "biography", Code: "unknown", Message: "is not a known field"}}, response.Items[1].Fields)
+	s.Require().Equal(1, s.countAuthors())
+}
+
+func (s *ServiceTestSuite) TestBatchCreate_BestEffort() {
+	// Act
+	rec, response := s.sendBatch(http.MethodPost, "/authors:batch?mode=best_effort", []Author{
+		{Name: "valid", Bio: "valid bio"},
+		{Name: "missing bio"},
+	})
+
+	// Assert
+	s.Require().Equal(http.StatusMultiStatus, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusCreated, response.Items[0].Status)
+	s.Require().Equal(http.StatusBadRequest, response.Items[1].Status)
+	s.Require().Equal(1, s.countAuthors())
+}
+
+func (s *ServiceTestSuite) TestBatchPatch_AtomicRollsBack() {
+	// Arrange
+	first, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{Name: "first", Bio: "bio"})
+	s.Require().NoError(err)
+	second, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{Name: "second", Bio: "bio"})
+	s.Require().NoError(err)
+
+	name := "renamed"
+	items := []AuthorBatchPatch{
+		{ID: first.ID, Version: first.Version, AuthorPartialUpdate: AuthorPartialUpdate{Name: &name}},
+		{ID: second.ID, Version: second.Version + 1, AuthorPartialUpdate: AuthorPartialUpdate{Name: &name}},
+	}
+
+	// Act
+	rec, response := s.sendBatch(http.MethodPatch, "/authors:batch", items)
+
+	// Assert
+	s.Require().Equal(http.StatusPreconditionFailed, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusFailedDependency, response.Items[0].Status)
+	s.Require().Equal(http.StatusPreconditionFailed, response.Items[1].Status)
+
+	got, err := s.queries.GetAuthor(context.Background(), first.ID)
+	s.Require().NoError(err)
+	s.Require().Equal("first", got.Name)
+
+	// Act: best effort applies what it can
+	rec, response = s.sendBatch(http.MethodPatch, "/authors:batch?mode=best_effort", items)
+
+	// Assert
+	s.Require().Equal(http.StatusMultiStatus, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusOK, response.Items[0].Status)
+	s.Require().Equal(http.StatusPreconditionFailed, response.Items[1].Status)
+
+	got, err = s.queries.GetAuthor(context.Background(), first.ID)
+	s.Require().NoError(err)
+	s.Require().Equal("renamed", got.Name)
+}
+
+func (s *ServiceTestSuite) TestBatchDelete() {
+	// Arrange
+	first, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{Name: "first", Bio: "bio"})
+	s.Require().NoError(err)
+	second, err := s.queries.CreateAuthor(context.Background(), database.CreateAuthorParams{Name: "second", Bio: "bio"})
+	s.Require().NoError(err)
+
+	// Act
+	rec, response := s.sendBatch(http.MethodDelete, "/authors:batch", []AuthorBatchDelete{
+		{ID: first.ID, Version: first.Version},
+		{ID: second.ID, Version: second.Version},
+	})
+
+	// Assert
+	s.Require().Equal(http.StatusOK, rec.Result().StatusCode)
+	s.Require().Equal(http.StatusNoContent, response.Items[0].Status)
+	s.Require().Equal(http.StatusNoContent, response.Items[1].Status)
+
+	_, err = s.queries.GetAuthor(context.Background(), first.ID)
+	s.Require().ErrorIs(err, sql.ErrNoRows)
+	_, err = s.queries.GetAuthor(context.Background(), second.ID)
+	s.Require().ErrorIs(err, sql.ErrNoRows)
+}
+
+func (s *ServiceTestSuite) TestBatch_UnknownAction() {
+	// Act
+	rec, _ := s.sendBatch(http.MethodPost, "/authors:import", []Author{{Name: "name", Bio: "bio"}})
+
+	// Assert
+	s.Require().Equal(http.StatusNotFound, rec.Result().StatusCode)
+}
+
+func (s *ServiceTestSuite) TestBatch_Empty() {
+	// Act
+	rec, _ := s.sendBatch(http.MethodPost, "/authors:batch", []Author{})
+
+	// Assert
+	s.Require().Equal(http.StatusBadRequest, rec.Result().StatusCode)
+}
+
+func (s *ServiceTestSuite) decodeProblem(rec *httptest.ResponseRecorder) apperror.Problem {
+	s.Require().Equal(apperror.ProblemContentType, rec.Header().Get("Content-Type"))
+	var problem apperror.Problem
+	s.Require().NoError(json.NewDecoder(rec.Result().Body).Decode(&problem))
+	return problem
 }
@@ -0,0 +1,222 @@
+package authors
+
+import (
+	_ "embed"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"github.com/potatowhite/restfulapi/pkg/openapi"
+	"net/http"
+	"strconv"
+)
+
+// Spec is the OpenAPI document of the authors API as generated by OpenAPI.
+// It is committed so changes to the API show up in review; TestOpenAPISpec
+// fails when it is out of date and rewrites it when run with -update.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// batchPath is the path the Batch handler answers on, which gin only knows
+// as the /authors:action route.
+const batchPath = "/authors:batch"
+
+// OpenAPI describes the routes RegisterHandlers sets up.
+func OpenAPI() *openapi.Document {
+	doc := openapi.New("Authors API", "1.0.0")
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+		"apiKey": {Type: "apiKey", In: "header", Name: auth.APIKeyHeader},
+	}
+	doc.Security = []map[string][]string{{"bearer": {}}, {"apiKey": {}}}
+	d := describer{doc}
+
+	idParams := doc.Parameters(PathParameters{}, "path")
+	withID := func(params ...*openapi.Parameter) []*openapi.Parameter {
+		return append(append([]*openapi.Parameter{}, idParams...), params...)
+	}
+
+	doc.Add(http.MethodPost, "/authors", &openapi.Operation{
+		OperationID: "createAuthor",
+		Summary:     "Create an author",
+		RequestBody: d.body(Author{}),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusCreated: d.author("The created author"),
+		}, http.StatusBadRequest, http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodGet, "/authors/:id", &openapi.Operation{
+		OperationID: "getAuthor",
+		Summary:     "Get an author",
+		Parameters:  withID(ifNoneMatch, ifModifiedSince),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK:          d.author("The author"),
+			http.StatusNotModified: {Description: "The client's copy is current"},
+		}, http.StatusBadRequest, http.StatusNotFound),
+	})
+	doc.Add(http.MethodPut, "/authors/:id", &openapi.Operation{
+		OperationID: "replaceAuthor",
+		Summary:     "Replace an author",
+		Parameters:  withID(ifMatch),
+		RequestBody: d.body(Author{}),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK: d.author("The updated author"),
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusPreconditionRequired, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPatch, "/authors/:id", &openapi.Operation{
+		OperationID: "updateAuthor",
+		Summary:     "Update some fields of an author",
+		Parameters:  withID(ifMatch),
+		RequestBody: d.body(AuthorPartialUpdate{}),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK: d.author("The updated author"),
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusPreconditionRequired, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodDelete, "/authors/:id", &openapi.Operation{
+		OperationID: "deleteAuthor",
+		Summary:     "Delete an author",
+		Parameters:  withID(append([]*openapi.Parameter{ifMatch}, doc.Parameters(DeleteParameters{}, "query")...)...),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusNoContent: {Description: "The author is deleted, or did not exist"},
+		}, http.StatusBadRequest, http.StatusPreconditionFailed, http.StatusPreconditionRequired, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodPost, "/authors/:id/restore", &openapi.Operation{
+		OperationID: "restoreAuthor",
+		Summary:     "Restore a deleted author",
+		Parameters:  withID(),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK: d.author("The restored author"),
+		}, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable),
+	})
+	doc.Add(http.MethodGet, "/authors/:id/history", &openapi.Operation{
+		OperationID: "getAuthorHistory",
+		Summary:     "List the changes made to an author",
+		Parameters:  withID(),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK:        {Description: "The changes, oldest first", Content: doc.JSON([]*AuthorAuditEntry{})},
+			http.StatusNoContent: {Description: "The author has no history"},
+		}, http.StatusBadRequest),
+	})
+	doc.Add(http.MethodGet, "/authors", &openapi.Operation{
+		OperationID: "listAuthors",
+		Summary:     "List authors",
+		Parameters:  append(doc.Parameters(ListParameters{}, "query"), ifNoneMatch),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK: {
+				Description: "A page of authors",
+				Headers:     map[string]*openapi.Header{"ETag": etagHeader},
+				Content:     doc.JSON(AuthorPage{}),
+			},
+			http.StatusNoContent:   {Description: "No author matches"},
+			http.StatusNotModified: {Description: "The client's copy is current"},
+		}, http.StatusBadRequest),
+	})
+	doc.Add(http.MethodGet, "/authors/search", &openapi.Operation{
+		OperationID: "searchAuthors",
+		Summary:     "Search the bios of authors",
+		Parameters:  doc.Parameters(SearchParameters{}, "query"),
+		Responses: d.responses(map[int]*openapi.Response{
+			http.StatusOK:        {Description: "The matching authors, best match first", Content: doc.JSON(AuthorSearchResults{})},
+			http.StatusNoContent: {Description: "No author matches"},
+		}, http.StatusBadRequest),
+	})
+
+	batchParams := doc.Parameters(BatchParameters{}, "query")
+	for _, batch := range []struct {
+		method, id, summary string
+		success             int
+		items               interface{}
+	}{
+		{http.MethodPost, "createAuthors", "Create authors in bulk", http.StatusCreated, []Author{}},
+		{http.MethodPatch, "updateAuthors", "Update authors in bulk", http.StatusOK, []AuthorBatchPatch{}},
+		{http.MethodDelete, "deleteAuthors", "Delete authors in bulk", http.StatusOK, []AuthorBatchDelete{}},
+	} {
+		results := func(description string) *openapi.Response {
+			return &openapi.Response{Description: description, Content: doc.JSON(BatchResponse{})}
+		}
+		responses := map[int]*openapi.Response{
+			batch.success:          results("Every item was applied"),
+			http.StatusMultiStatus: results("The result of every item of a best-effort batch"),
+		}
+		// an atomic batch answers with the status of the item that failed
+		for _, status := range []int{http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed,
+			http.StatusUnprocessableEntity, http.StatusServiceUnavailable} {
+			responses[status] = results("An item failed and nothing was applied")
+		}
+		op := &openapi.Operation{
+			OperationID: batch.id,
+			Summary:     batch.summary,
+			Parameters:  batchParams,
+			RequestBody: &openapi.RequestBody{Required: true, Content: doc.JSON(batch.items)},
+			Responses:   d.responses(responses, http.StatusBadRequest, http.StatusRequestEntityTooLarge),
+			ItemErrors:  true,
+		}
+		// invalid items and failing items are reported like any other item
+		// when the request itself could be handled
+		for _, status := range []int{http.StatusBadRequest, http.StatusInternalServerError} {
+			op.Responses[strconv.Itoa(status)].Content["application/json"] = &openapi.MediaType{Schema: doc.Schema(BatchResponse{})}
+		}
+		doc.Add(batch.method, batchPath, op)
+	}
+
+	return doc
+}
+
+var (
+	ifMatch = &openapi.Parameter{
+		Name:        "If-Match",
+		In:          "header",
+		Description: "The ETag of the version of the author the change is based on",
+		Required:    true,
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	ifNoneMatch = &openapi.Parameter{
+		Name:        "If-None-Match",
+		In:          "header",
+		Description: "ETags of copies the client already has",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	ifModifiedSince = &openapi.Parameter{
+		Name:   "If-Modified-Since",
+		In:     "header",
+		Schema: &openapi.Schema{Type: "string"},
+	}
+	etagHeader = &openapi.Header{Schema: &openapi.Schema{Type: "string"}}
+)
+
+type describer struct {
+	doc *openapi.Document
+}
+
+func (d describer) body(v interface{}) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: d.doc.JSON(v)}
+}
+
+func (d describer) author(description string) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Headers: map[string]*openapi.Header{
+			"ETag":          etagHeader,
+			"Last-Modified": {Schema: &openapi.Schema{Type: "string"}},
+		},
+		Content: d.doc.JSON(Author{}),
+	}
+}
+
+// responses keys responses by status code and adds a problem response for
+// each of problems, along with the 401, 403 and 500 every operation may
+// answer.
+func (d describer) responses(responses map[int]*openapi.Response, problems ...int) map[string]*openapi.Response {
+	byCode := map[string]*openapi.Response{}
+	for status, response := range responses {
+		byCode[strconv.Itoa(status)] = response
+	}
+	problem := d.doc.Schema(apperror.Problem{})
+	for _, status := range append(problems, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusInternalServerError) {
+		byCode[strconv.Itoa(status)] = &openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]*openapi.MediaType{apperror.ProblemContentType: {Schema: problem}},
+		}
+	}
+	return byCode
+}
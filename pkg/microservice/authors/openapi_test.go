@@ -0,0 +1,42 @@
+package authors
+
+import (
+	"flag"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/openapi"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite openapi.json from the routes and DTOs")
+
+func TestOpenAPISpec(t *testing.T) {
+	generated, err := OpenAPI().MarshalIndent()
+	require.NoError(t, err)
+
+	if *update {
+		require.NoError(t, os.WriteFile("openapi.json", generated, 0o644))
+		return
+	}
+	require.Equal(t, string(generated), string(Spec),
+		"openapi.json is out of date, run go test ./pkg/microservice/authors -run TestOpenAPISpec -update")
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	router := gin.New()
+	NewAuthorHandler(nil, nil).RegisterHandlers(router)
+
+	routes := map[string]bool{}
+	for _, route := range router.Routes() {
+		path := strings.Replace(route.Path, "/authors:action", batchPath, 1)
+		routes[route.Method+" "+openapi.PathTemplate(path)] = true
+	}
+	described := map[string]bool{}
+	for key := range OpenAPI().Operations() {
+		described[key] = true
+	}
+
+	require.Equal(t, routes, described)
+}
@@ -2,106 +2,453 @@ package authors
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
 	"github.com/potatowhite/restfulapi/pkg/database"
-	"log"
-	"os"
+	"html"
+	"log/slog"
+	"strings"
 )
 
 var (
-	logger = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
+	// ErrAuthorNotFound is returned when the author does not exist or has
+	// been deleted.
+	ErrAuthorNotFound = apperror.NotFound("author_not_found", "author not found")
+	// ErrVersionMismatch is returned by writes whose expected version is no
+	// longer the current version of the author.
+	ErrVersionMismatch = apperror.PreconditionFailed("version_mismatch", "author has been modified")
+	// ErrAuthorNotDeleted is returned when restoring an author that is not
+	// deleted.
+	ErrAuthorNotDeleted = apperror.Conflict("author_not_deleted", "author is not deleted")
+	// ErrNotApplied marks the items of an atomic batch that were rolled back
+	// because another item failed.
+	ErrNotApplied = apperror.NotApplied("not_applied", "not applied because another item of the batch failed")
 )
 
+// BatchMode controls what happens to a batch when some of its items fail.
+type BatchMode string
+
+const (
+	// BatchAtomic applies either every item of the batch or none of them.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort applies every item that succeeds, each in its own
+	// savepoint of the batch transaction.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+// BatchOutcome is the result of a single item of a batch operation. Author
+// is nil for deletions and for items that were not applied.
+type BatchOutcome struct {
+	Author *Author
+	Err    error
+}
+
+// AuthorService manages authors. Get, List and Search read from a replica
+// when replicas are configured, so they may briefly lag behind writes.
 type AuthorService interface {
 	Create(ctx context.Context, cmd database.CreateAuthorParams) (*Author, error)
 	Get(ctx context.Context, id int64) (*Author, error)
 	Put(ctx context.Context, cmd database.UpdateAuthorParams) (*Author, error)
 	Patch(ctx context.Context, cmd database.PartialUpdateAuthorParams) (*Author, error)
-	Delete(ctx context.Context, id int64) error
-	List(ctx context.Context) ([]*Author, error)
+	Delete(ctx context.Context, cmd database.DeleteAuthorParams) error
+	Restore(ctx context.Context, id int64) (*Author, error)
+	Purge(ctx context.Context, cmd database.DeleteAuthorParams) error
+	History(ctx context.Context, id int64) ([]*AuthorAuditEntry, error)
+	CreateBatch(ctx context.Context, cmds []database.CreateAuthorParams, mode BatchMode) ([]BatchOutcome, error)
+	PatchBatch(ctx context.Context, cmds []database.PartialUpdateAuthorParams, mode BatchMode) ([]BatchOutcome, error)
+	DeleteBatch(ctx context.Context, cmds []database.DeleteAuthorParams, mode BatchMode) ([]BatchOutcome, error)
+	List(ctx context.Context, cmd database.ListAuthorsParams) (*AuthorPage, error)
+	Search(ctx context.Context, cmd database.SearchAuthorsParams) (*AuthorSearchResults, error)
 	Truncate(ctx context.Context) error
 }
 
 type authorService struct {
-	queries *database.Queries
+	tx *database.TxManager
 }
 
 func (a *authorService) Truncate(ctx context.Context) error {
-	if err := a.queries.TruncateAuthor(ctx); err != nil {
-		return logging(fmt.Errorf("error truncating authors: %w", err))
+	if err := a.tx.Queries().TruncateAuthor(ctx); err != nil {
+		return logging(ctx, fmt.Errorf("error truncating authors: %w", err))
 	}
 	return nil
 }
 
 func (a *authorService) Create(ctx context.Context, cmd database.CreateAuthorParams) (*Author, error) {
-	author, err := a.queries.CreateAuthor(ctx, cmd)
+	var author database.Author
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		created, err := q.CreateAuthor(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		author = created.Author()
+		return audit(ctx, q, auditCreate, nil, &author)
+	})
 	if err != nil {
-		return nil, logging(fmt.Errorf("error creating author: %w", err))
+		return nil, logging(ctx, fmt.Errorf("error creating author: %w", err))
 	}
 
 	return fromDB(author), nil
 }
 
-func logging(err error) error {
-	logger.Printf(err.Error())
-	return err
+// logging logs err and translates it into the domain error callers see, so
+// database details never leave the service.
+func logging(ctx context.Context, err error) error {
+	slog.ErrorContext(ctx, "author service call failed", "error", err)
+	return domainError(err)
+}
+
+func domainError(err error) error {
+	err = database.TranslateError(err)
+	var appErr *apperror.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrAuthorNotFound
+	case errors.As(err, &appErr):
+		return appErr
+	default:
+		return apperror.Internal(err)
+	}
 }
 
 func (a *authorService) Patch(ctx context.Context, cmd database.PartialUpdateAuthorParams) (*Author, error) {
-	author, err := a.queries.PartialUpdateAuthor(ctx, cmd)
+	var author database.Author
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		before, err := lockCurrent(ctx, q, cmd.ID, cmd.Version)
+		if err != nil {
+			return err
+		}
+		updated, err := q.PartialUpdateAuthor(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		author = updated.Author()
+		return audit(ctx, q, auditPatch, &before, &author)
+	})
 	if err != nil {
-		return nil, logging(fmt.Errorf("error updating author: %w", err))
+		return nil, logging(ctx, fmt.Errorf("error updating author: %w", err))
 	}
 	return fromDB(author), nil
 }
 
 func (a *authorService) Get(ctx context.Context, id int64) (*Author, error) {
-	author, err := a.queries.GetAuthor(ctx, id)
+	author, err := a.tx.ReadQueries(ctx).GetAuthor(ctx, id)
 	if err != nil {
-		return nil, logging(err)
+		return nil, logging(ctx, err)
 	}
 
-	return fromDB(author), nil
+	return fromDB(author.Author()), nil
 }
 
 func (a *authorService) Put(ctx context.Context, cmd database.UpdateAuthorParams) (*Author, error) {
-	author, err := a.queries.UpdateAuthor(ctx, cmd)
+	var author database.Author
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		before, err := lockCurrent(ctx, q, cmd.ID, cmd.Version)
+		if err != nil {
+			return err
+		}
+		updated, err := q.UpdateAuthor(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		author = updated.Author()
+		return audit(ctx, q, auditUpdate, &before, &author)
+	})
 	if err != nil {
-		return nil, logging(err)
+		return nil, logging(ctx, err)
 	}
 	return fromDB(author), nil
 }
 
-func (a *authorService) Delete(ctx context.Context, id int64) error {
-	err := a.queries.DeleteAuthor(ctx, id)
+func (a *authorService) Delete(ctx context.Context, cmd database.DeleteAuthorParams) error {
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		before, err := lockCurrent(ctx, q, cmd.ID, cmd.Version)
+		if err == sql.ErrNoRows {
+			// deleting a missing author is a no-op, a stale version is not
+			return nil
+		} else if err != nil {
+			return err
+		}
+		deleted, err := q.DeleteAuthor(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		after := deleted.Author()
+		return audit(ctx, q, auditDelete, &before, &after)
+	})
 	if err != nil {
-		return logging(err)
+		return logging(ctx, err)
 	}
 	return nil
 }
 
-func (a *authorService) List(ctx context.Context) ([]*Author, error) {
-	authorList, err := a.queries.ListAuthors(ctx)
+func (a *authorService) Restore(ctx context.Context, id int64) (*Author, error) {
+	var author database.Author
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		locked, err := q.LockAuthor(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !locked.DeletedAt.Valid {
+			return ErrAuthorNotDeleted
+		}
+		restored, err := q.RestoreAuthor(ctx, id)
+		if err != nil {
+			return err
+		}
+		before, author := locked.Author(), restored.Author()
+		return audit(ctx, q, auditRestore, &before, &author)
+	})
 	if err != nil {
-		return nil, logging(err)
+		return nil, logging(ctx, fmt.Errorf("error restoring author: %w", err))
 	}
+	return fromDB(author), nil
+}
 
-	var apiAuthors []*Author
+func (a *authorService) Purge(ctx context.Context, cmd database.DeleteAuthorParams) error {
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		// deleted authors can be purged too, so lockCurrent does not apply
+		locked, err := q.LockAuthor(ctx, cmd.ID)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		before := locked.Author()
+		if before.Version != cmd.Version {
+			return ErrVersionMismatch
+		}
+		if _, err := q.PurgeAuthor(ctx, cmd.ID); err != nil {
+			return err
+		}
+		return audit(ctx, q, auditPurge, &before, nil)
+	})
+	if err != nil {
+		return logging(ctx, fmt.Errorf("error purging author: %w", err))
+	}
+	return nil
+}
+
+func (a *authorService) History(ctx context.Context, id int64) ([]*AuthorAuditEntry, error) {
+	entries, err := a.tx.Queries().ListAuthorAudit(ctx, id)
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error reading author history: %w", err))
+	}
+
+	var history []*AuthorAuditEntry
+	for _, entry := range entries {
+		history = append(history, &AuthorAuditEntry{
+			ID:        entry.ID,
+			Action:    entry.Action,
+			Actor:     entry.Actor,
+			Before:    entry.Before,
+			After:     entry.After,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+	return history, nil
+}
+
+func (a *authorService) CreateBatch(ctx context.Context, cmds []database.CreateAuthorParams, mode BatchMode) ([]BatchOutcome, error) {
+	createOne := func(q *database.Queries, i int) (*database.Author, error) {
+		created, err := q.CreateAuthor(ctx, cmds[i])
+		if err != nil {
+			return nil, err
+		}
+		author := created.Author()
+		return &author, audit(ctx, q, auditCreate, nil, &author)
+	}
+	if mode == BatchBestEffort {
+		return a.runBatch(ctx, len(cmds), mode, createOne)
+	}
+
+	// all or nothing, so the whole batch goes in with a single statement
+	outcomes := make([]BatchOutcome, len(cmds))
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		params := database.CreateAuthorsParams{
+			Names: make([]string, len(cmds)),
+			Bios:  make([]string, len(cmds)),
+		}
+		for i, cmd := range cmds {
+			params.Names[i] = cmd.Name
+			params.Bios[i] = cmd.Bio
+		}
+		created, err := q.CreateAuthors(ctx, params)
+		if err != nil {
+			return err
+		}
+		for _, row := range created {
+			author := row.Author()
+			if err := audit(ctx, q, auditCreate, nil, &author); err != nil {
+				return err
+			}
+			outcomes[row.Ordinality-1] = BatchOutcome{Author: fromDB(author)}
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil, logging(ctx, fmt.Errorf("error creating authors: %w", err))
+	}
+	if err != nil {
+		// the statement does not tell which item failed, so the batch is
+		// replayed item by item to report it like the other batches do
+		slog.WarnContext(ctx, "Creating the batch in one statement failed, retrying item by item", "error", err)
+		return a.runBatch(ctx, len(cmds), mode, createOne)
+	}
+	return outcomes, nil
+}
+
+func (a *authorService) PatchBatch(ctx context.Context, cmds []database.PartialUpdateAuthorParams, mode BatchMode) ([]BatchOutcome, error) {
+	return a.runBatch(ctx, len(cmds), mode, func(q *database.Queries, i int) (*database.Author, error) {
+		before, err := lockCurrent(ctx, q, cmds[i].ID, cmds[i].Version)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := q.PartialUpdateAuthor(ctx, cmds[i])
+		if err != nil {
+			return nil, err
+		}
+		author := updated.Author()
+		return &author, audit(ctx, q, auditPatch, &before, &author)
+	})
+}
+
+func (a *authorService) DeleteBatch(ctx context.Context, cmds []database.DeleteAuthorParams, mode BatchMode) ([]BatchOutcome, error) {
+	return a.runBatch(ctx, len(cmds), mode, func(q *database.Queries, i int) (*database.Author, error) {
+		before, err := lockCurrent(ctx, q, cmds[i].ID, cmds[i].Version)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		deleted, err := q.DeleteAuthor(ctx, cmds[i])
+		if err != nil {
+			return nil, err
+		}
+		after := deleted.Author()
+		return nil, audit(ctx, q, auditDelete, &before, &after)
+	})
+}
+
+// runBatch applies n items in one transaction. In atomic mode the first
+// failing item rolls back the whole batch; in best-effort mode every item
+// runs in its own savepoint. Item failures are reported in the outcomes, the
+// returned error is only set when the transaction itself fails.
+func (a *authorService) runBatch(ctx context.Context, n int, mode BatchMode, apply func(q *database.Queries, i int) (*database.Author, error)) ([]BatchOutcome, error) {
+	var (
+		outcomes   []BatchOutcome
+		itemFailed bool
+	)
+	err := a.tx.InTx(ctx, func(q *database.Queries) error {
+		outcomes = make([]BatchOutcome, n)
+		itemFailed = false
+		for i := range outcomes {
+			var author *database.Author
+			run := func() (err error) {
+				author, err = apply(q, i)
+				return err
+			}
+			if mode == BatchBestEffort {
+				if err := q.Savepoint(ctx, run); err != nil {
+					outcomes[i].Err = domainError(err)
+				}
+			} else if err := run(); err != nil {
+				for j := range outcomes {
+					outcomes[j] = BatchOutcome{Err: ErrNotApplied}
+				}
+				outcomes[i].Err = domainError(err)
+				itemFailed = true
+				return err
+			}
+			if author != nil && outcomes[i].Err == nil {
+				outcomes[i].Author = fromDB(*author)
+			}
+		}
+		return nil
+	})
+	if err != nil && !itemFailed {
+		return nil, logging(ctx, fmt.Errorf("error applying batch: %w", err))
+	}
+	return outcomes, nil
+}
+
+// lockCurrent locks the author row for the rest of the transaction and checks
+// that it is live and still at the version the caller expects.
+func lockCurrent(ctx context.Context, q *database.Queries, id int64, version int64) (database.Author, error) {
+	locked, err := q.LockAuthor(ctx, id)
+	if err != nil {
+		return database.Author{}, err
+	}
+	author := locked.Author()
+	if author.DeletedAt.Valid {
+		return author, sql.ErrNoRows
+	}
+	if author.Version != version {
+		return author, ErrVersionMismatch
+	}
+	return author, nil
+}
+
+func (a *authorService) List(ctx context.Context, cmd database.ListAuthorsParams) (*AuthorPage, error) {
+	// fetch one extra row to find out whether there is a next page
+	limit := cmd.Limit
+	cmd.Limit = limit + 1
+	authorList, err := a.tx.ReadQueries(ctx).ListAuthors(ctx, cmd)
+	if err != nil {
+		return nil, logging(ctx, err)
+	}
+
+	page := &AuthorPage{}
+	if len(authorList) > int(limit) {
+		authorList = authorList[:limit]
+		last := authorList[len(authorList)-1]
+		page.NextCursor = encodeCursor(cursor{Name: last.Name, ID: last.ID, Sort: formatSort(cmd.Sort)})
+	}
 	for _, author := range authorList {
-		apiAuthors = append(apiAuthors, fromDB(author))
+		page.Items = append(page.Items, fromDB(author))
+	}
+
+	return page, nil
+}
+
+func (a *authorService) Search(ctx context.Context, cmd database.SearchAuthorsParams) (*AuthorSearchResults, error) {
+	rows, err := a.tx.ReadQueries(ctx).SearchAuthors(ctx, cmd)
+	if err != nil {
+		return nil, logging(ctx, fmt.Errorf("error searching authors: %w", err))
+	}
+
+	results := &AuthorSearchResults{}
+	for _, row := range rows {
+		results.Items = append(results.Items, &AuthorSearchResult{
+			Author:   Author{ID: row.ID, Name: row.Name, Bio: row.Bio},
+			Rank:     row.Rank,
+			Headline: highlight(row.Headline),
+		})
 	}
 
-	return apiAuthors, nil
+	return results, nil
+}
+
+// highlighter marks up the matches SearchAuthors delimits in a headline.
+var highlighter = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+// highlight escapes headline for HTML, bios being free text, and wraps its
+// matches in <mark> tags.
+func highlight(headline string) string {
+	return highlighter.Replace(html.EscapeString(headline))
 }
 
 func fromDB(dbAuthor database.Author) *Author {
 	return &Author{
-		ID:   dbAuthor.ID,
-		Name: dbAuthor.Name,
-		Bio:  dbAuthor.Bio,
+		ID:        dbAuthor.ID,
+		Name:      dbAuthor.Name,
+		Bio:       dbAuthor.Bio,
+		Version:   dbAuthor.Version,
+		UpdatedAt: dbAuthor.UpdatedAt,
 	}
 }
 
-func NewAuthorService(dbQueries *database.Queries) AuthorService {
-	return &authorService{queries: dbQueries}
+func NewAuthorService(tx *database.TxManager) AuthorService {
+	return &authorService{tx: tx}
 }
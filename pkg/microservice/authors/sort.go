@@ -0,0 +1,60 @@
+package authors
+
+import (
+	"fmt"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"strings"
+)
+
+// sortFields whitelists the fields a client may sort the author list by.
+var sortFields = map[string]database.AuthorSortColumn{
+	"name": database.AuthorSortName,
+	"id":   database.AuthorSortID,
+}
+
+// parseSort turns a sort expression such as "-name,id" into database sort
+// keys. A leading '-' sorts descending. id is appended as a tie-breaker when
+// missing so that keyset pagination always sees a unique ordering.
+func parseSort(expr string) ([]database.AuthorSort, error) {
+	if expr == "" {
+		expr = "name"
+	}
+
+	var (
+		sorts []database.AuthorSort
+		seen  = map[database.AuthorSortColumn]bool{}
+	)
+	for _, field := range strings.Split(expr, ",") {
+		desc := strings.HasPrefix(field, "-")
+		column, ok := sortFields[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return nil, invalidSort(fmt.Sprintf("invalid sort field %q", field))
+		}
+		if seen[column] {
+			return nil, invalidSort(fmt.Sprintf("duplicate sort field %q", field))
+		}
+		seen[column] = true
+		sorts = append(sorts, database.AuthorSort{Column: column, Desc: desc})
+	}
+	if !seen[database.AuthorSortID] {
+		sorts = append(sorts, database.AuthorSort{Column: database.AuthorSortID})
+	}
+	return sorts, nil
+}
+
+func formatSort(sorts []database.AuthorSort) string {
+	fields := make([]string, len(sorts))
+	for i, s := range sorts {
+		fields[i] = string(s.Column)
+		if s.Desc {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+func invalidSort(message string) error {
+	return apperror.Validation("invalid_sort", message,
+		apperror.FieldError{Field: "sort", Code: "invalid", Message: message})
+}
@@ -0,0 +1,127 @@
+package authors
+
+import (
+	"context"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedAuthorService wraps every AuthorService call in a span.
+type tracedAuthorService struct {
+	next   AuthorService
+	tracer trace.Tracer
+}
+
+// WithTracing traces the calls to service.
+func WithTracing(service AuthorService) AuthorService {
+	return &tracedAuthorService{next: service, tracer: otel.Tracer("github.com/potatowhite/restfulapi/pkg/microservice/authors")}
+}
+
+func (t *tracedAuthorService) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "AuthorService."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed with err. Domain errors such as a
+// missing author count as failures too, their kind tells them apart.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func authorID(id int64) attribute.KeyValue {
+	return attribute.Int64("author.id", id)
+}
+
+func batchAttributes(size int, mode BatchMode) []attribute.KeyValue {
+	return []attribute.KeyValue{attribute.Int("batch.size", size), attribute.String("batch.mode", string(mode))}
+}
+
+func (t *tracedAuthorService) Create(ctx context.Context, cmd database.CreateAuthorParams) (author *Author, err error) {
+	ctx, span := t.start(ctx, "Create")
+	defer func() { endSpan(span, err) }()
+	return t.next.Create(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Get(ctx context.Context, id int64) (author *Author, err error) {
+	ctx, span := t.start(ctx, "Get", authorID(id))
+	defer func() { endSpan(span, err) }()
+	return t.next.Get(ctx, id)
+}
+
+func (t *tracedAuthorService) Put(ctx context.Context, cmd database.UpdateAuthorParams) (author *Author, err error) {
+	ctx, span := t.start(ctx, "Put", authorID(cmd.ID))
+	defer func() { endSpan(span, err) }()
+	return t.next.Put(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Patch(ctx context.Context, cmd database.PartialUpdateAuthorParams) (author *Author, err error) {
+	ctx, span := t.start(ctx, "Patch", authorID(cmd.ID))
+	defer func() { endSpan(span, err) }()
+	return t.next.Patch(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Delete(ctx context.Context, cmd database.DeleteAuthorParams) (err error) {
+	ctx, span := t.start(ctx, "Delete", authorID(cmd.ID))
+	defer func() { endSpan(span, err) }()
+	return t.next.Delete(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Restore(ctx context.Context, id int64) (author *Author, err error) {
+	ctx, span := t.start(ctx, "Restore", authorID(id))
+	defer func() { endSpan(span, err) }()
+	return t.next.Restore(ctx, id)
+}
+
+func (t *tracedAuthorService) Purge(ctx context.Context, cmd database.DeleteAuthorParams) (err error) {
+	ctx, span := t.start(ctx, "Purge", authorID(cmd.ID))
+	defer func() { endSpan(span, err) }()
+	return t.next.Purge(ctx, cmd)
+}
+
+func (t *tracedAuthorService) History(ctx context.Context, id int64) (entries []*AuthorAuditEntry, err error) {
+	ctx, span := t.start(ctx, "History", authorID(id))
+	defer func() { endSpan(span, err) }()
+	return t.next.History(ctx, id)
+}
+
+func (t *tracedAuthorService) CreateBatch(ctx context.Context, cmds []database.CreateAuthorParams, mode BatchMode) (outcomes []BatchOutcome, err error) {
+	ctx, span := t.start(ctx, "CreateBatch", batchAttributes(len(cmds), mode)...)
+	defer func() { endSpan(span, err) }()
+	return t.next.CreateBatch(ctx, cmds, mode)
+}
+
+func (t *tracedAuthorService) PatchBatch(ctx context.Context, cmds []database.PartialUpdateAuthorParams, mode BatchMode) (outcomes []BatchOutcome, err error) {
+	ctx, span := t.start(ctx, "PatchBatch", batchAttributes(len(cmds), mode)...)
+	defer func() { endSpan(span, err) }()
+	return t.next.PatchBatch(ctx, cmds, mode)
+}
+
+func (t *tracedAuthorService) DeleteBatch(ctx context.Context, cmds []database.DeleteAuthorParams, mode BatchMode) (outcomes []BatchOutcome, err error) {
+	ctx, span := t.start(ctx, "DeleteBatch", batchAttributes(len(cmds), mode)...)
+	defer func() { endSpan(span, err) }()
+	return t.next.DeleteBatch(ctx, cmds, mode)
+}
+
+func (t *tracedAuthorService) List(ctx context.Context, cmd database.ListAuthorsParams) (page *AuthorPage, err error) {
+	ctx, span := t.start(ctx, "List")
+	defer func() { endSpan(span, err) }()
+	return t.next.List(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Search(ctx context.Context, cmd database.SearchAuthorsParams) (results *AuthorSearchResults, err error) {
+	ctx, span := t.start(ctx, "Search")
+	defer func() { endSpan(span, err) }()
+	return t.next.Search(ctx, cmd)
+}
+
+func (t *tracedAuthorService) Truncate(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "Truncate")
+	defer func() { endSpan(span, err) }()
+	return t.next.Truncate(ctx)
+}
@@ -0,0 +1,36 @@
+package authors
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+type missingAuthorService struct {
+	AuthorService
+}
+
+func (missingAuthorService) Get(context.Context, int64) (*Author, error) {
+	return nil, ErrAuthorNotFound
+}
+
+func TestWithTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, err := WithTracing(missingAuthorService{}).Get(context.Background(), 42)
+
+	require.Equal(t, ErrAuthorNotFound, err)
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "AuthorService.Get", spans[0].Name())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Contains(t, spans[0].Attributes(), attribute.Int64("author.id", 42))
+}
@@ -0,0 +1,30 @@
+package openapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRegisterHandlers_DocsOnlyRunRedocFromItsCDNPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	RegisterHandlers(router, []byte(`{}`))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	policy := rec.Header().Get("Content-Security-Policy")
+	require.Contains(t, policy, "default-src 'none'")
+	// every script the page loads is allowed by the policy
+	scripts := regexp.MustCompile(`<script src="([^"]+)"`).FindAllStringSubmatch(rec.Body.String(), -1)
+	require.NotEmpty(t, scripts)
+	for _, script := range scripts {
+		require.True(t, strings.HasPrefix(script[1], "https://cdn.redoc.ly/redoc/v2.1.3/"), script[1])
+	}
+}
@@ -0,0 +1,341 @@
+// Package openapi builds OpenAPI 3.1 documents from the request and response
+// structs of the services, so that the published description follows the
+// json, form, uri and binding tags the handlers actually use.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	// Security lists the security schemes, by name, any of which the
+	// operations accept.
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	// In and Name locate the key of an apiKey scheme.
+	In   string `json:"in,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// ItemErrors marks operations taking an array body that report invalid
+	// items one by one in their response instead of rejecting the request.
+	ItemErrors bool `json:"x-item-errors,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema 2020-12 the generated documents use.
+// Type is either a single type name or, for nullable values, a list of them.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+func New(title string, version string) *Document {
+	return &Document{
+		OpenAPI:    Version,
+		Info:       Info{Title: title, Version: version},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+}
+
+// Add describes the operation served for method on a gin route such as
+// /authors/:id, which is written as /authors/{id}.
+func (d *Document) Add(method string, route string, op *Operation) {
+	path := PathTemplate(route)
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	switch method {
+	case "GET":
+		item.Get = op
+	case "PUT":
+		item.Put = op
+	case "POST":
+		item.Post = op
+	case "DELETE":
+		item.Delete = op
+	case "PATCH":
+		item.Patch = op
+	default:
+		panic("openapi: unsupported method " + method)
+	}
+}
+
+// Operations returns the operations of the document keyed by "METHOD path".
+func (d *Document) Operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+	for path, item := range d.Paths {
+		for method, op := range map[string]*Operation{
+			"GET": item.Get, "PUT": item.Put, "POST": item.Post, "DELETE": item.Delete, "PATCH": item.Patch,
+		} {
+			if op != nil {
+				ops[method+" "+path] = op
+			}
+		}
+	}
+	return ops
+}
+
+// PathTemplate turns the :name segments of a gin route into {name}.
+func PathTemplate(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Parse reads a document rendered by MarshalIndent.
+func Parse(spec []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing OpenAPI document: %w", err)
+	}
+	return &doc, nil
+}
+
+// MarshalIndent renders the document the way it is committed and served.
+func (d *Document) MarshalIndent() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Schema returns a schema for the type of v. Structs are registered as
+// components named after their Go type and referenced.
+func (d *Document) Schema(v interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// JSON describes a JSON body of the type of v.
+func (d *Document) JSON(v interface{}) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: d.Schema(v)}}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		// any JSON value
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return d.schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			closed := false
+			schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: &closed}
+			// registered before the fields so recursive types terminate
+			d.Components.Schemas[t.Name()] = schema
+			d.addFields(schema, t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// addFields adds the JSON fields of struct type t to schema, flattening
+// embedded structs the way encoding/json does.
+func (d *Document) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			d.addFields(schema, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := d.schemaOf(field.Type)
+		if applyBinding(property, field) {
+			schema.Required = append(schema.Required, name)
+		}
+		if field.Type.Kind() == reflect.Pointer && property.Ref == "" {
+			// pointer fields are the ones a client may send as null
+			property.Type = []string{property.Type.(string), "null"}
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// Parameters describes the fields of struct v tagged with in's tag ("form"
+// for query parameters, "uri" for path parameters) as parameters.
+func (d *Document) Parameters(v interface{}, in string) []*Parameter {
+	tag := map[string]string{"query": "form", "path": "uri"}[in]
+	t := reflect.TypeOf(v)
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get(tag)
+		if name == "" {
+			continue
+		}
+		schema := d.schemaOf(field.Type)
+		required := applyBinding(schema, field)
+		params = append(params, &Parameter{
+			Name:     name,
+			In:       in,
+			Required: required || in == "path",
+			Schema:   schema,
+		})
+	}
+	return params
+}
+
+// applyBinding translates the validator rules of field's binding tag into
+// schema keywords, and reports whether the field is required.
+func applyBinding(schema *Schema, field reflect.StructField) bool {
+	required := false
+	for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "min", "max":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				panic("openapi: invalid " + rule + " on " + field.Name)
+			}
+			limit(schema, name, n)
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		}
+	}
+	return required
+}
+
+// limit sets the bound the validator's min or max rule puts on schema, which
+// depends on the type like it does for the validator.
+func limit(schema *Schema, rule string, n int) {
+	var bound **int
+	switch schema.Type {
+	case "string":
+		bound = map[string]**int{"min": &schema.MinLength, "max": &schema.MaxLength}[rule]
+	case "integer", "number":
+		bound = map[string]**int{"min": &schema.Minimum, "max": &schema.Maximum}[rule]
+	case "array":
+		bound = map[string]**int{"min": &schema.MinItems, "max": &schema.MaxItems}[rule]
+	default:
+		panic(fmt.Sprintf("openapi: %s is not supported on %v", rule, schema.Type))
+	}
+	*bound = &n
+}
@@ -0,0 +1,67 @@
+package openapi
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type embedded struct {
+	Note *string `json:"note,omitempty" binding:"omitempty,max=8"`
+}
+
+type widget struct {
+	ID      int64
+	Name    string    `json:"name" binding:"required,min=1,max=32"`
+	Kind    string    `json:"kind" binding:"omitempty,oneof=small large"`
+	Tags    []string  `json:"tags" binding:"max=3"`
+	Hidden  string    `json:"-"`
+	Created time.Time `json:"created"`
+	embedded
+}
+
+type widgetQuery struct {
+	Limit int32  `form:"limit" binding:"omitempty,min=1,max=100"`
+	Q     string `form:"q" binding:"required"`
+	Other string
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestSchema(t *testing.T) {
+	doc := New("test", "1")
+
+	require.Equal(t, &Schema{Ref: "#/components/schemas/widget"}, doc.Schema(widget{}))
+	require.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"ID":      {Type: "integer", Format: "int64"},
+			"name":    {Type: "string", MinLength: intPtr(1), MaxLength: intPtr(32)},
+			"kind":    {Type: "string", Enum: []string{"small", "large"}},
+			"tags":    {Type: "array", Items: &Schema{Type: "string"}, MaxItems: intPtr(3)},
+			"created": {Type: "string", Format: "date-time"},
+			"note":    {Type: []string{"string", "null"}, MaxLength: intPtr(8)},
+		},
+		Required:             []string{"name"},
+		AdditionalProperties: new(bool),
+	}, doc.Components.Schemas["widget"])
+}
+
+func TestParameters(t *testing.T) {
+	doc := New("test", "1")
+
+	require.Equal(t, []*Parameter{
+		{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Format: "int32", Minimum: intPtr(1), Maximum: intPtr(100)}},
+		{Name: "q", In: "query", Required: true, Schema: &Schema{Type: "string"}},
+	}, doc.Parameters(widgetQuery{}, "query"))
+}
+
+func TestAdd(t *testing.T) {
+	doc := New("test", "1")
+	get, patch := &Operation{OperationID: "get"}, &Operation{OperationID: "patch"}
+
+	doc.Add("GET", "/widgets/:id", get)
+	doc.Add("PATCH", "/widgets/:id", patch)
+
+	require.Equal(t, map[string]*Operation{"GET /widgets/{id}": get, "PATCH /widgets/{id}": patch}, doc.Operations())
+}
@@ -0,0 +1,431 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrRequestTooLarge is returned for request bodies over
+// ValidatorOptions.MaxBodyBytes.
+var ErrRequestTooLarge = apperror.RequestTooLarge("request_too_large", "the request body is too large")
+
+// ValidatorOptions configures a Validator.
+type ValidatorOptions struct {
+	// MaxBodyBytes is the largest request body read for validation; larger
+	// ones are rejected with ErrRequestTooLarge. Zero leaves bodies
+	// unbounded.
+	MaxBodyBytes int64
+}
+
+// Validator checks requests, and optionally responses, against the
+// operations a document describes. Routes the document does not describe
+// are left alone.
+type Validator struct {
+	doc  *Document
+	ops  map[string]*Operation
+	opts ValidatorOptions
+}
+
+func NewValidator(doc *Document, opts ValidatorOptions) *Validator {
+	return &Validator{doc: doc, ops: doc.Operations(), opts: opts}
+}
+
+// Requests rejects requests that do not match their operation with a
+// validation error, before they reach the handler.
+func (v *Validator) Requests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, templated := v.operation(c)
+		if op == nil {
+			return
+		}
+		fields, err := v.validateRequest(c, op, templated)
+		if err == nil && len(fields) > 0 {
+			err = apperror.Validation("invalid_request", "the request is invalid", fields...)
+		}
+		if err != nil {
+			_ = c.Error(err)
+			c.Abort()
+		}
+	}
+}
+
+// Responses passes every response that does not match its operation to
+// report. It is meant for tests, to catch the handlers drifting away from
+// the published contract, and has to run before anything that writes
+// responses, including apperror.Middleware.
+func (v *Validator) Responses(report func(c *gin.Context, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op, _ := v.operation(c)
+		if op == nil {
+			return
+		}
+		w := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if err := v.validateResponse(op, w.Status(), w.Header().Get("Content-Type"), w.body.Bytes()); err != nil {
+			report(c, fmt.Errorf("%s %s: %w", c.Request.Method, c.Request.URL.Path, err))
+		}
+	}
+}
+
+// operation finds the operation for the route gin matched, or for the
+// literal request path when the document spells out a path that gin can
+// only match with a parameter. templated reports which of the two it is.
+func (v *Validator) operation(c *gin.Context) (op *Operation, templated bool) {
+	if op, ok := v.ops[c.Request.Method+" "+PathTemplate(c.FullPath())]; ok && c.FullPath() != "" {
+		return op, true
+	}
+	return v.ops[c.Request.Method+" "+c.Request.URL.Path], false
+}
+
+// validateRequest returns the fields of the request that do not match op, or
+// an error when the request cannot be checked at all.
+func (v *Validator) validateRequest(c *gin.Context, op *Operation, templated bool) ([]apperror.FieldError, error) {
+	var fields []apperror.FieldError
+	query := c.Request.URL.Query()
+	for _, param := range op.Parameters {
+		var raw string
+		switch param.In {
+		case "path":
+			if !templated {
+				continue
+			}
+			raw = c.Param(param.Name)
+		case "query":
+			raw = query.Get(param.Name)
+		case "header":
+			// handlers answer missing precondition headers with their own
+			// statuses, so only present headers are checked
+			raw = c.GetHeader(param.Name)
+		}
+		if raw == "" {
+			if param.Required && param.In != "header" {
+				fields = append(fields, apperror.FieldError{Field: param.Name, Code: "required", Message: "is required"})
+			}
+			continue
+		}
+		value, fieldErr := v.parseParameter(raw, param.Schema)
+		if fieldErr != nil {
+			fieldErr.Field = param.Name
+			fields = append(fields, *fieldErr)
+			continue
+		}
+		fields = append(fields, v.validate(value, param.Schema, param.Name)...)
+	}
+
+	if op.RequestBody != nil {
+		bodyFields, err := v.validateBody(c, op)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, bodyFields...)
+	}
+	return fields, nil
+}
+
+// parseParameter converts a path, query or header parameter into the JSON
+// value its schema describes.
+func (v *Validator) parseParameter(raw string, schema *Schema) (interface{}, *apperror.FieldError) {
+	switch t := v.resolve(schema).Type; t {
+	case "integer":
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, &apperror.FieldError{Code: "type", Message: "must be an integer"}
+		}
+		return json.Number(raw), nil
+	case "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return nil, &apperror.FieldError{Code: "type", Message: "must be a number"}
+		}
+		return json.Number(raw), nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, &apperror.FieldError{Code: "type", Message: "must be a boolean"}
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+func (v *Validator) validateBody(c *gin.Context, op *Operation) ([]apperror.FieldError, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		return []apperror.FieldError{{Field: "Content-Type", Code: "content_type", Message: "must be one of " + mediaTypes(op.RequestBody.Content)}}, nil
+	}
+
+	if v.opts.MaxBodyBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, v.opts.MaxBodyBytes)
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, ErrRequestTooLarge
+	} else if err != nil {
+		return []apperror.FieldError{{Field: "body", Code: "unreadable", Message: "could not be read"}}, nil
+	}
+	// the handler reads the body again
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return []apperror.FieldError{{Field: "body", Code: "required", Message: "is required"}}, nil
+		}
+		return nil, nil
+	}
+	value, err := decode(body)
+	if err != nil {
+		return []apperror.FieldError{{Field: "body", Code: "json", Message: "is not valid JSON"}}, nil
+	}
+
+	schema := content.Schema
+	if op.ItemErrors {
+		// the items are checked by the handler, one by one
+		schema = &Schema{Type: "array"}
+	}
+	return v.validate(value, schema, ""), nil
+}
+
+func (v *Validator) validateResponse(op *Operation, status int, contentType string, body []byte) error {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return fmt.Errorf("status %d is not described", status)
+	}
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("status %d is described without a body, but has one", status)
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("status %d has content type %q instead of one of %s", status, contentType, mediaTypes(response.Content))
+	}
+	value, err := decode(body)
+	if err != nil {
+		return fmt.Errorf("status %d has a body that is not valid JSON: %w", status, err)
+	}
+	if fields := v.validate(value, content.Schema, ""); len(fields) > 0 {
+		var problems []string
+		for _, field := range fields {
+			problems = append(problems, field.Field+" "+field.Message)
+		}
+		return fmt.Errorf("status %d has an invalid body: %s", status, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate checks a decoded JSON value against schema. path names the value
+// in the field errors; it is empty for the whole body.
+func (v *Validator) validate(value interface{}, schema *Schema, path string) []apperror.FieldError {
+	schema = v.resolve(schema)
+	types := schemaTypes(schema.Type)
+	if len(types) == 0 {
+		return nil
+	}
+	if value == nil {
+		if contains(types, "null") {
+			return nil
+		}
+		return []apperror.FieldError{typeError(path, types)}
+	}
+
+	fail := func(code string, format string, args ...interface{}) []apperror.FieldError {
+		return []apperror.FieldError{{Field: fieldName(path), Code: code, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	switch value := value.(type) {
+	case string:
+		if !contains(types, "string") {
+			return []apperror.FieldError{typeError(path, types)}
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+			return fail("oneof", "must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+		n := utf8.RuneCountInString(value)
+		if schema.MinLength != nil && n < *schema.MinLength {
+			return fail("min", "must be at least %d characters long", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			return fail("max", "must be at most %d characters long", *schema.MaxLength)
+		}
+	case bool:
+		if !contains(types, "boolean") {
+			return []apperror.FieldError{typeError(path, types)}
+		}
+	case json.Number:
+		n, err := value.Float64()
+		if contains(types, "integer") {
+			i, err := value.Int64()
+			if err != nil {
+				return fail("type", "must be an integer")
+			}
+			if schema.Format == "int32" && (i < math.MinInt32 || i > math.MaxInt32) {
+				return fail("type", "must be a 32-bit integer")
+			}
+		} else if !contains(types, "number") || err != nil {
+			return []apperror.FieldError{typeError(path, types)}
+		}
+		if schema.Minimum != nil && n < float64(*schema.Minimum) {
+			return fail("min", "must be at least %d", *schema.Minimum)
+		}
+		if schema.Maximum != nil && n > float64(*schema.Maximum) {
+			return fail("max", "must be at most %d", *schema.Maximum)
+		}
+	case []interface{}:
+		if !contains(types, "array") {
+			return []apperror.FieldError{typeError(path, types)}
+		}
+		if schema.MinItems != nil && len(value) < *schema.MinItems {
+			return fail("min", "must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(value) > *schema.MaxItems {
+			return fail("max", "must have at most %d items", *schema.MaxItems)
+		}
+		var fields []apperror.FieldError
+		if schema.Items != nil {
+			for i, item := range value {
+				fields = append(fields, v.validate(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return fields
+	case map[string]interface{}:
+		if !contains(types, "object") {
+			return []apperror.FieldError{typeError(path, types)}
+		}
+		var fields []apperror.FieldError
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				fields = append(fields, apperror.FieldError{Field: join(path, name), Code: "required", Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					fields = append(fields, apperror.FieldError{Field: join(path, name), Code: "unknown", Message: "is not a known field"})
+				}
+				continue
+			}
+			fields = append(fields, v.validate(value[name], property, join(path, name))...)
+		}
+		return fields
+	}
+	return nil
+}
+
+// resolve follows a reference to the components of the document.
+func (v *Validator) resolve(schema *Schema) *Schema {
+	for schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved, ok := v.doc.Components.Schemas[name]
+		if !ok {
+			panic("openapi: unresolvable reference " + schema.Ref)
+		}
+		schema = resolved
+	}
+	return schema
+}
+
+func decode(body []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after the JSON value")
+	}
+	return value, nil
+}
+
+// schemaTypes returns the type keyword as a list, whether it was built as a
+// string or []string or parsed into a string or []interface{}.
+func schemaTypes(t interface{}) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []interface{}:
+		types := make([]string, len(t))
+		for i, name := range t {
+			types[i], _ = name.(string)
+		}
+		return types
+	}
+	return nil
+}
+
+func typeError(path string, types []string) apperror.FieldError {
+	return apperror.FieldError{Field: fieldName(path), Code: "type", Message: "must be of type " + strings.Join(types, " or ")}
+}
+
+func mediaTypes(content map[string]*MediaType) string {
+	types := make([]string, 0, len(content))
+	for mediaType := range content {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingWriter keeps a copy of the response body for validation.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,164 @@
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type gadget struct {
+	Name string  `json:"name" binding:"required,max=8"`
+	Size string  `json:"size" binding:"omitempty,oneof=small large"`
+	Note *string `json:"note,omitempty"`
+}
+
+type gadgetPath struct {
+	ID int64 `uri:"id" binding:"required"`
+}
+
+type gadgetQuery struct {
+	Limit int32 `form:"limit" binding:"omitempty,min=1,max=10"`
+}
+
+// newGadgetRouter serves gadgets as described, except that GET /gadgets/:id
+// answers with whatever body is set in the X-Body header.
+func newGadgetRouter(t *testing.T, reported *[]error) *gin.Engine {
+	doc := New("gadgets", "1")
+	doc.Add(http.MethodPost, "/gadgets", &Operation{
+		OperationID: "createGadget",
+		RequestBody: &RequestBody{Required: true, Content: doc.JSON(gadget{})},
+		Responses:   map[string]*Response{"201": {Description: "created", Content: doc.JSON(gadget{})}},
+	})
+	doc.Add(http.MethodGet, "/gadgets/:id", &Operation{
+		OperationID: "getGadget",
+		Parameters:  append(doc.Parameters(gadgetPath{}, "path"), doc.Parameters(gadgetQuery{}, "query")...),
+		Responses:   map[string]*Response{"200": {Description: "the gadget", Content: doc.JSON(gadget{})}},
+	})
+
+	// round trip the document like the served one
+	b, err := doc.MarshalIndent()
+	require.NoError(t, err)
+	doc, err = Parse(b)
+	require.NoError(t, err)
+	validator := NewValidator(doc, ValidatorOptions{MaxBodyBytes: 64})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(validator.Responses(func(c *gin.Context, err error) { *reported = append(*reported, err) }))
+	router.Use(apperror.Middleware())
+	router.Use(validator.Requests())
+	router.POST("/gadgets", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusCreated, "application/json", body)
+	})
+	router.GET("/gadgets/:id", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(c.GetHeader("X-Body")))
+	})
+	return router
+}
+
+func send(router *gin.Engine, method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, request)
+	return rec
+}
+
+func problemFields(t *testing.T, rec *httptest.ResponseRecorder) []apperror.FieldError {
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	var problem apperror.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, "invalid_request", problem.Code)
+	return problem.Errors
+}
+
+func TestValidator_AcceptsValidRequests(t *testing.T) {
+	var reported []error
+	router := newGadgetRouter(t, &reported)
+
+	rec := send(router, http.MethodPost, "/gadgets", "application/json; charset=utf-8", `{"name":"lamp","size":"small","note":null}`)
+
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.JSONEq(t, `{"name":"lamp","size":"small","note":null}`, rec.Body.String())
+	require.Empty(t, reported)
+}
+
+func TestValidator_RejectsInvalidBodies(t *testing.T) {
+	var reported []error
+	router := newGadgetRouter(t, &reported)
+
+	tests := []struct {
+		contentType, body string
+		fields            []apperror.FieldError
+	}{
+		{"text/plain", `{"name":"lamp"}`, []apperror.FieldError{{Field: "Content-Type", Code: "content_type", Message: "must be one of application/json"}}},
+		{"application/json", ``, []apperror.FieldError{{Field: "body", Code: "required", Message: "is required"}}},
+		{"application/json", `{"name":`, []apperror.FieldError{{Field: "body", Code: "json", Message: "is not valid JSON"}}},
+		{"application/json", `[]`, []apperror.FieldError{{Field: "body", Code: "type", Message: "must be of type object"}}},
+		{"application/json", `{"size":"huge","color":"red"}`, []apperror.FieldError{
+			{Field: "name", Code: "required", Message: "is required"},
+			{Field: "color", Code: "unknown", Message: "is not a known field"},
+			{Field: "size", Code: "oneof", Message: "must be one of small, large"},
+		}},
+		{"application/json", `{"name":"a long name","note":1}`, []apperror.FieldError{
+			{Field: "name", Code: "max", Message: "must be at most 8 characters long"},
+			{Field: "note", Code: "type", Message: "must be of type string or null"},
+		}},
+	}
+	for _, tt := range tests {
+		rec := send(router, http.MethodPost, "/gadgets", tt.contentType, tt.body)
+		require.Equal(t, tt.fields, problemFields(t, rec), tt.body)
+	}
+}
+
+func TestValidator_RejectsBodiesOverTheLimit(t *testing.T) {
+	var reported []error
+	router := newGadgetRouter(t, &reported)
+
+	rec := send(router, http.MethodPost, "/gadgets", "application/json", `{"name":"lamp","note":"`+strings.Repeat("x", 64)+`"}`)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	var problem apperror.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, ErrRequestTooLarge.Code, problem.Code)
+}
+
+func TestValidator_RejectsInvalidParameters(t *testing.T) {
+	var reported []error
+	router := newGadgetRouter(t, &reported)
+
+	require.Equal(t, []apperror.FieldError{{Field: "id", Code: "type", Message: "must be an integer"}},
+		problemFields(t, send(router, http.MethodGet, "/gadgets/abc", "", "")))
+	require.Equal(t, []apperror.FieldError{{Field: "limit", Code: "max", Message: "must be at most 10"}},
+		problemFields(t, send(router, http.MethodGet, "/gadgets/1?limit=11", "", "")))
+	require.Equal(t, []apperror.FieldError{{Field: "limit", Code: "type", Message: "must be an integer"}},
+		problemFields(t, send(router, http.MethodGet, "/gadgets/1?limit=ten", "", "")))
+}
+
+func TestValidator_ReportsResponsesThatDrift(t *testing.T) {
+	var reported []error
+	router := newGadgetRouter(t, &reported)
+
+	request := httptest.NewRequest(http.MethodGet, "/gadgets/1", nil)
+	request.Header.Set("X-Body", `{"name":"lamp","weight":3}`)
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	require.Len(t, reported, 1)
+	require.EqualError(t, reported[0], "GET /gadgets/1: status 200 has an invalid body: weight is not a known field")
+
+	// the problem response for an invalid request is not described either
+	reported = nil
+	send(router, http.MethodGet, "/gadgets/abc", "", "")
+
+	require.Len(t, reported, 1)
+	require.EqualError(t, reported[0], "GET /gadgets/abc: status 400 is not described")
+}
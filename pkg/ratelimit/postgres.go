@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// idleBucket is how long a bucket has to go unused before it is deleted. A
+// bucket of a limit that takes longer to refill completely is reset early.
+const idleBucket = time.Hour
+
+// PostgresStore keeps the buckets in the rate_limit_buckets table, so every
+// replica counts against the same limits. Taking a token is a single
+// statement.
+type PostgresStore struct {
+	queries *database.Queries
+
+	mu      sync.Mutex
+	sweptAt time.Time
+}
+
+func NewPostgresStore(queries *database.Queries) *PostgresStore {
+	return &PostgresStore{queries: queries}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.maybeSweep()
+	row, err := s.queries.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Key:   key,
+		Burst: limit.burst(),
+		Rate:  limit.rate(),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(limit, row.Tokens, row.Allowed), nil
+}
+
+// maybeSweep deletes idle buckets in the background, at most once every
+// sweepInterval.
+func (s *PostgresStore) maybeSweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.sweptAt) < sweepInterval {
+		return
+	}
+	s.sweptAt = time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := s.queries.DeleteIdleRateLimitBuckets(ctx, time.Now().Add(-idleBucket)); err != nil {
+			slog.ErrorContext(ctx, "error deleting idle rate limit buckets", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/potatowhite/restfulapi/cmd/config"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPostgresStore(t *testing.T) {
+	cfg, err := config.Read()
+	require.NoError(t, err)
+	postgres, err := database.NewPostgres(database.Options{URL: string(cfg.Database.URL), Host: cfg.Database.Host, Port: cfg.Database.Port, User: cfg.Database.Username, Password: string(cfg.Database.Password), Dbname: cfg.Database.Dbname, SSLMode: cfg.Database.SSLMode})
+	require.NoError(t, err)
+	migrator, err := database.NewMigrator(postgres.DB)
+	require.NoError(t, err)
+	require.NoError(t, migrator.Up(context.Background()))
+	_, err = postgres.DB.Exec("TRUNCATE rate_limit_buckets")
+	require.NoError(t, err)
+
+	// two stores stand in for two replicas sharing the buckets
+	replicas := []*PostgresStore{
+		NewPostgresStore(database.New(postgres.DB)),
+		NewPostgresStore(database.New(postgres.DB)),
+	}
+	limit := Limit{Requests: 1, Per: time.Hour, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		result, err := replicas[i%2].Take(context.Background(), "client", limit)
+		require.NoError(t, err)
+		require.True(t, result.Allowed, i)
+		require.Equal(t, 2-i, result.Remaining)
+	}
+	result, err := replicas[1].Take(context.Background(), "client", limit)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+	require.InDelta(t, time.Hour.Seconds(), result.RetryAfter.Seconds(), 5)
+
+	result, err = replicas[0].Take(context.Background(), "other", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+}
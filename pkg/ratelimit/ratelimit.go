@@ -0,0 +1,155 @@
+// Package ratelimit limits how often each client may call each route, with
+// token buckets kept in memory or in Postgres.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrRateLimited = apperror.TooManyRequests("rate_limited", "too many requests, retry later")
+
+// Limit allows Requests every Per on average, and up to Burst at once. A
+// limit without Requests is no limit at all.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	// Burst is the size of the bucket, Requests when not set.
+	Burst int
+}
+
+// rate is how many tokens the bucket is refilled with every second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// Result is the state of a bucket after a request tried to take a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the bucket holds a token again.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(limit.burst()),
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((limit.burst() - tokens) / limit.rate()),
+	}
+	if tokens < 1 {
+		result.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take refills the bucket of key according to limit and takes a token
+	// from it, if it holds one.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Limits are the limits of the routes, by method and route template, such
+// as "GET /authors/:id". Paths gin can only match with a parameter, such as
+// "/authors:batch", are keyed by their literal path instead. Routes without
+// a limit of their own share Default.
+type Limits struct {
+	Default Limit
+	Routes  map[string]Limit
+}
+
+// Middleware answers requests of clients that exceeded the limit of their
+// route with 429 Too Many Requests. It has to run after authentication to
+// tell clients apart by their credentials. Every limited response carries
+// the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of
+// draft-ietf-httpapi-ratelimit-headers.
+func Middleware(store Store, limits Limits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		limit, ok := limits.Routes[route]
+		if !ok {
+			route = c.Request.Method + " " + c.Request.URL.Path
+			limit, ok = limits.Routes[route]
+		}
+		if !ok {
+			limit, route = limits.Default, "default"
+		}
+		take(c, store, route+" "+ClientKey(c), limit)
+	}
+}
+
+// PerIP answers requests from IP addresses that exceeded limit, over all
+// routes, with 429 Too Many Requests. It runs before authentication, so
+// floods of invalid credentials are limited before they are checked
+// against the database.
+func PerIP(store Store, limit Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		take(c, store, "per-ip ip:"+c.ClientIP(), limit)
+	}
+}
+
+// take takes a token from the bucket of key and aborts the request when
+// there is none.
+func take(c *gin.Context, store Store, key string, limit Limit) {
+	if limit.Requests <= 0 {
+		return
+	}
+	result, err := store.Take(c.Request.Context(), key, limit)
+	if err != nil {
+		// an unavailable store must not take the API down with it
+		slog.ErrorContext(c.Request.Context(), "error taking a rate limit token, letting the request through", "error", err)
+		return
+	}
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+	if !result.Allowed {
+		c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+		_ = c.Error(ErrRateLimited)
+		c.Abort()
+	}
+}
+
+// ClientKey identifies the caller by its API key, by the subject of its
+// token, or, for anonymous requests, by its IP address. It has to be called
+// after authentication.
+func ClientKey(c *gin.Context) string {
+	principal, ok := auth.PrincipalFrom(c.Request.Context())
+	if key := c.GetHeader(auth.APIKeyHeader); key != "" && ok {
+		// the key itself must not end up in the store
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	if ok {
+		return "sub:" + principal.Subject
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
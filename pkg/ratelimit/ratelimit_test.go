@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/apperror"
+	"github.com/potatowhite/restfulapi/pkg/auth"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_TokenBucket(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 1, Per: time.Second, Burst: 3}
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(context.Background(), "client", limit)
+		require.NoError(t, err)
+		require.True(t, result.Allowed)
+		require.Equal(t, i, result.Remaining)
+	}
+
+	result, err := store.Take(context.Background(), "client", limit)
+	require.NoError(t, err)
+	require.False(t, result.Allowed)
+	require.Equal(t, time.Second, result.RetryAfter)
+	require.Equal(t, 3*time.Second, result.Reset)
+
+	// other clients have buckets of their own
+	result, err = store.Take(context.Background(), "other", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+
+	now = now.Add(1500 * time.Millisecond)
+	result, err = store.Take(context.Background(), "client", limit)
+	require.NoError(t, err)
+	require.True(t, result.Allowed)
+	require.Equal(t, 0, result.Remaining)
+	require.Equal(t, 500*time.Millisecond, result.RetryAfter)
+}
+
+func TestMemoryStore_SweepsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 10, Per: time.Second}
+
+	_, err := store.Take(context.Background(), "client", limit)
+	require.NoError(t, err)
+	require.Len(t, store.buckets, 1)
+
+	now = now.Add(sweepInterval)
+	_, err = store.Take(context.Background(), "other", limit)
+	require.NoError(t, err)
+	require.Len(t, store.buckets, 1)
+	require.Contains(t, store.buckets, "other")
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(func(c *gin.Context) {
+		if subject := c.GetHeader("X-Test-Subject"); subject != "" {
+			c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), &auth.Principal{Subject: subject}))
+		}
+	})
+	router.Use(Middleware(NewMemoryStore(), Limits{
+		Default: Limit{Requests: 100, Per: time.Minute},
+		Routes:  map[string]Limit{"GET /authors": {Requests: 2, Per: time.Minute}},
+	}))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	router.GET("/authors", ok)
+	router.GET("/authors/:id", ok)
+
+	send := func(path, subject string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-Test-Subject", subject)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := send("/authors", "alice")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "30", rec.Header().Get("RateLimit-Reset"))
+	require.Equal(t, http.StatusOK, send("/authors", "alice").Code)
+
+	rec = send("/authors", "alice")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "30", rec.Header().Get("Retry-After"))
+	require.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	var problem apperror.Problem
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&problem))
+	require.Equal(t, ErrRateLimited.Code, problem.Code)
+
+	// other routes and other clients are limited separately
+	rec = send("/authors/1", "alice")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "100", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, http.StatusOK, send("/authors", "bob").Code)
+	require.Equal(t, http.StatusOK, send("/authors", "").Code)
+}
+
+func TestMiddleware_LimitsRoutesByTheirLiteralPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(Middleware(NewMemoryStore(), Limits{
+		Default: Limit{Requests: 100, Per: time.Minute},
+		Routes:  map[string]Limit{"POST /authors:batch": {Requests: 1, Per: time.Minute}},
+	}))
+	// registered like the batch route, which gin cannot match literally
+	router.POST("/authors:action", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	send := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/authors:batch", nil))
+		return rec
+	}
+
+	rec := send()
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, http.StatusTooManyRequests, send().Code)
+}
+
+func TestPerIP_LimitsBeforeAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apperror.Middleware())
+	router.Use(PerIP(NewMemoryStore(), Limit{Requests: 2, Per: time.Minute}))
+	var checked int
+	router.Use(func(c *gin.Context) {
+		checked++
+		_ = c.Error(auth.ErrInvalidAPIKey)
+		c.Abort()
+	})
+	router.GET("/authors", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/authors", nil)
+		request.RemoteAddr = ip + ":1234"
+		request.Header.Set(auth.APIKeyHeader, "ak_guess")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, request)
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, send("192.0.2.1").Code)
+	require.Equal(t, http.StatusUnauthorized, send("192.0.2.1").Code)
+	// further guesses are not even checked
+	require.Equal(t, http.StatusTooManyRequests, send("192.0.2.1").Code)
+	require.Equal(t, 2, checked)
+	require.Equal(t, http.StatusUnauthorized, send("192.0.2.2").Code)
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (Result, error) {
+	return Result{}, context.DeadlineExceeded
+}
+
+func TestMiddleware_LetsRequestsThroughWhenTheStoreFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware(failingStore{}, Limits{Default: Limit{Requests: 1, Per: time.Minute}}))
+	router.GET("/authors", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authors", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,143 @@
+// Package server runs the HTTP server and shuts it down gracefully, so
+// rolling deploys do not drop in-flight requests.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultShutdownTimeout is how long in-flight requests are waited for when
+// no shutdown timeout is configured.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// Options configure the timeouts of the server; zero means no timeout,
+// except for ShutdownTimeout. See http.Server for the others.
+type Options struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownDelay keeps accepting requests for a while after shutdown
+	// started, so load balancers notice the failing readiness check and
+	// stop sending new ones before the listener closes.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the listener closed, before their connections are closed.
+	ShutdownTimeout time.Duration
+	// Signals start the shutdown, SIGINT and SIGTERM by default.
+	Signals []os.Signal
+}
+
+type Server struct {
+	http            *http.Server
+	shutdownDelay   time.Duration
+	shutdownTimeout time.Duration
+	signals         []os.Signal
+	onShutdown      []func()
+	closers         []io.Closer
+}
+
+func New(addr string, handler http.Handler, opts Options) *Server {
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return &Server{
+		http: &http.Server{
+			Addr:              addr,
+			Handler:           handler,
+			ReadTimeout:       opts.ReadTimeout,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
+		},
+		shutdownDelay:   opts.ShutdownDelay,
+		shutdownTimeout: opts.ShutdownTimeout,
+		signals:         opts.Signals,
+	}
+}
+
+// OnShutdown has fn called as soon as shutdown starts, before the shutdown
+// delay.
+func (s *Server) OnShutdown(fn func()) {
+	s.onShutdown = append(s.onShutdown, fn)
+}
+
+// CloseOnShutdown has c closed once the in-flight requests are done, such
+// as the database pool they use. Closers are closed in the order they were
+// added.
+func (s *Server) CloseOnShutdown(c io.Closer) {
+	s.closers = append(s.closers, c)
+}
+
+// Run listens on the server's address and serves until a shutdown signal
+// arrives or ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return errors.Join(err, s.close())
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until a shutdown signal arrives or ctx is done.
+// It then runs the OnShutdown functions, waits for the shutdown delay, stops
+// accepting connections, waits up to the shutdown timeout for in-flight
+// requests, and closes what was registered with CloseOnShutdown.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, stop := signal.NotifyContext(ctx, s.signals...)
+	defer stop()
+
+	served := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "addr", listener.Addr().String())
+		served <- s.http.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return errors.Join(err, s.close())
+	case <-ctx.Done():
+	}
+	// a second signal terminates the process the default way
+	stop()
+
+	for _, fn := range s.onShutdown {
+		fn()
+	}
+	if s.shutdownDelay > 0 {
+		slog.Info("Shutting down after a delay", "delay", s.shutdownDelay)
+		time.Sleep(s.shutdownDelay)
+	}
+	slog.Info("Shutting down, waiting for in-flight requests", "timeout", s.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	err := s.http.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("In-flight requests did not finish in time, closing their connections", "error", err)
+		err = errors.Join(err, s.http.Close())
+	}
+	if served := <-served; !errors.Is(served, http.ErrServerClosed) {
+		err = errors.Join(err, served)
+	}
+	return errors.Join(err, s.close())
+}
+
+func (s *Server) close() error {
+	var errs []error
+	for _, c := range s.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type closer struct {
+	closed atomic.Bool
+}
+
+func (c *closer) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return listener
+}
+
+func TestServe_DrainsInFlightRequestsOnSignal(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "done")
+	})
+	srv := New("", handler, Options{ShutdownTimeout: 10 * time.Second, Signals: []os.Signal{syscall.SIGTERM}})
+	db := &closer{}
+	srv.CloseOnShutdown(db)
+	listener := listen(t)
+	addr := listener.Addr().String()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Serve(context.Background(), listener) }()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	<-started
+
+	require.NoError(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+	// new connections are refused while the request is still running
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.False(t, db.closed.Load(), "closed the database before the request finished")
+
+	close(release)
+	res := <-responses
+	require.NoError(t, res.err)
+	require.Equal(t, "done", res.body)
+	require.NoError(t, <-stopped)
+	require.True(t, db.closed.Load())
+}
+
+func TestServe_ClosesConnectionsAfterShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	srv := New("", handler, Options{ShutdownTimeout: 50 * time.Millisecond})
+	db := &closer{}
+	srv.CloseOnShutdown(db)
+	listener := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Serve(ctx, listener) }()
+	failed := make(chan error, 1)
+	go func() {
+		_, err := http.Get("http://" + listener.Addr().String())
+		failed <- err
+	}()
+	<-started
+
+	cancel()
+
+	require.ErrorIs(t, <-stopped, context.DeadlineExceeded)
+	require.Error(t, <-failed)
+	require.True(t, db.closed.Load())
+}
+
+func TestServe_KeepsServingDuringShutdownDelay(t *testing.T) {
+	srv := New("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Options{ShutdownDelay: 200 * time.Millisecond})
+	shuttingDown := make(chan struct{})
+	srv.OnShutdown(func() { close(shuttingDown) })
+	listener := listen(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Serve(ctx, listener) }()
+	cancel()
+	<-shuttingDown
+
+	res, err := http.Get("http://" + listener.Addr().String())
+	require.NoError(t, err)
+	res.Body.Close()
+	require.NoError(t, <-stopped)
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// DBTX creates a span for every query run on db, named after its sqlc
+// query, or after the SQL command of other statements. It is a
+// database.Wrapper.
+func DBTX(db database.DBTX) database.DBTX {
+	return &tracedDBTX{db: db, tracer: otel.Tracer(instrumentation)}
+}
+
+type tracedDBTX struct {
+	db     database.DBTX
+	tracer trace.Tracer
+}
+
+func (t *tracedDBTX) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := database.QueryName(query)
+	if name == "" {
+		name, _, _ = strings.Cut(strings.TrimSpace(query), " ")
+	}
+	return t.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query)),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := t.start(ctx, query)
+	result, err := t.db.ExecContext(ctx, query, args...)
+	end(span, err)
+	return result, err
+}
+
+func (t *tracedDBTX) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return t.db.PrepareContext(ctx, query)
+}
+
+// QueryContext ends the span when the first rows arrive; reading them is up
+// to the caller.
+func (t *tracedDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := t.start(ctx, query)
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	end(span, err)
+	return rows, err
+}
+
+func (t *tracedDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := t.start(ctx, query)
+	row := t.db.QueryRowContext(ctx, query, args...)
+	end(span, row.Err())
+	return row
+}
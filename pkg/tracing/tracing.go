@@ -0,0 +1,115 @@
+// Package tracing traces requests with OpenTelemetry, from the HTTP server
+// through the services down to the SQL queries.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"os"
+	"time"
+)
+
+// Exporters that Setup supports.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// flushTimeout bounds exporting the spans still buffered at shutdown.
+const flushTimeout = 5 * time.Second
+
+type Options struct {
+	ServiceName string
+	// Exporter is one of ExporterNone, ExporterOTLP, ExporterStdout and
+	// ExporterFile.
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector; the
+	// OTEL_EXPORTER_OTLP_* environment variables apply when it is empty.
+	Endpoint string
+	// Insecure sends spans to the collector over plain HTTP.
+	Insecure bool
+	// File receives the spans of ExporterFile, one JSON document each.
+	File string
+	// SampleRatio is the share of new traces that are recorded. Requests
+	// carrying a sampled traceparent are always recorded.
+	SampleRatio float64
+}
+
+// Provider exports the spans of the process until it is closed.
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	file     *os.File
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. With ExporterNone spans are still propagated, but not
+// recorded.
+func Setup(ctx context.Context, opts Options) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	p := &Provider{}
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return p, nil
+	case ExporterOTLP:
+		httpOpts := []otlptracehttp.Option{}
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, httpOpts...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		if p.file, err = os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("error opening trace file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(p.file))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	p.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(p.provider)
+	return p, nil
+}
+
+// Close exports the spans that are still buffered.
+func (p *Provider) Close() error {
+	var err error
+	if p.provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		err = p.provider.Shutdown(ctx)
+	}
+	if p.file != nil {
+		if closeErr := p.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
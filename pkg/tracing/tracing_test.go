@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/potatowhite/restfulapi/pkg/database"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+type fakeDBTX struct {
+	database.DBTX
+}
+
+func (fakeDBTX) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func TestMiddleware_ContinuesTheTraceDownToTheQueries(t *testing.T) {
+	recorder := record(t)
+	_, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	queries := database.New(DBTX(fakeDBTX{}))
+	router.DELETE("/authors/:id", func(c *gin.Context) {
+		_, _ = queries.PurgeAuthor(c.Request.Context(), 1)
+		_, _ = DBTX(fakeDBTX{}).ExecContext(c.Request.Context(), "SAVEPOINT unit_of_work")
+		c.Status(http.StatusInternalServerError)
+	})
+
+	request := httptest.NewRequest(http.MethodDelete, "/authors/1", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	purge, savepoint, server := spans[0], spans[1], spans[2]
+
+	require.Equal(t, "DELETE /authors/:id", server.Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	require.Equal(t, codes.Error, server.Status().Code)
+
+	require.Equal(t, "PurgeAuthor", purge.Name())
+	require.Equal(t, server.SpanContext().SpanID(), purge.Parent().SpanID())
+	require.Equal(t, "SAVEPOINT", savepoint.Name())
+}
+
+func TestSetup_FileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	provider, err := Setup(context.Background(), Options{ServiceName: "authorservice", Exporter: ExporterFile, File: path, SampleRatio: 1})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	require.NoError(t, provider.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var exported struct{ Name string }
+	require.NoError(t, json.Unmarshal(data, &exported))
+	require.Equal(t, "work", exported.Name)
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Options{Exporter: "carrier-pigeon"})
+	require.Error(t, err)
+}
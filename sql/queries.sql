@@ -1,39 +1,154 @@
 -- name: CreateAuthor :one
 INSERT INTO authors (name, bio)
 VALUES ($1, $2)
-    RETURNING *;
+    RETURNING id, name, bio, version, updated_at, deleted_at;
+
+-- name: CreateAuthors :many
+-- Ids are drawn up front so every created row can be joined back to the
+-- position of its input: RETURNING order is not guaranteed.
+WITH input AS MATERIALIZED (SELECT nextval(pg_get_serial_sequence('authors', 'id')) AS id, name, bio, ordinality
+                            FROM unnest(@names::VARCHAR(32)[], @bios::TEXT[]) WITH ORDINALITY AS i (name, bio, ordinality)),
+     created AS (
+         INSERT INTO authors (id, name, bio)
+             SELECT id, name, bio FROM input
+             RETURNING id, name, bio, version, updated_at, deleted_at)
+SELECT created.id, created.name, created.bio, created.version, created.updated_at, created.deleted_at, input.ordinality
+FROM created
+         JOIN input USING (id)
+ORDER BY input.ordinality;
 
 -- name: GetAuthor :one
-SELECT *
+SELECT id, name, bio, version, updated_at, deleted_at
 FROM authors
 WHERE id = $1
+  AND deleted_at IS NULL
     LIMIT 1;
 
+-- name: LockAuthor :one
+SELECT id, name, bio, version, updated_at, deleted_at
+FROM authors
+WHERE id = $1
+    FOR UPDATE;
+
 -- name: UpdateAuthor :one
 UPDATE authors
-SET name = $2,
-    bio  = $3
+SET name       = $2,
+    bio        = $3,
+    version    = version + 1,
+    updated_at = now()
 WHERE id = $1
-    RETURNING *;
+  AND version = $4
+  AND deleted_at IS NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at;
 
 -- name: PartialUpdateAuthor :one
 UPDATE authors
-SET name = CASE WHEN @update_name::boolean THEN @name::VARCHAR(32) ELSE name END,
-    bio  = CASE WHEN @update_bio::boolean THEN @bio::TEXT ELSE bio END
+SET name       = CASE WHEN @update_name::boolean THEN @name::VARCHAR(32) ELSE name END,
+    bio        = CASE WHEN @update_bio::boolean THEN @bio::TEXT ELSE bio END,
+    version    = version + 1,
+    updated_at = now()
 WHERE id = @id
-RETURNING *;
+  AND version = @version
+  AND deleted_at IS NULL
+RETURNING id, name, bio, version, updated_at, deleted_at;
 
--- name: DeleteAuthor :exec
+-- name: DeleteAuthor :one
+UPDATE authors
+SET deleted_at = now(),
+    version    = version + 1,
+    updated_at = now()
+WHERE id = $1
+  AND version = $2
+  AND deleted_at IS NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at;
+
+-- name: RestoreAuthor :one
+UPDATE authors
+SET deleted_at = NULL,
+    version    = version + 1,
+    updated_at = now()
+WHERE id = $1
+  AND deleted_at IS NOT NULL
+    RETURNING id, name, bio, version, updated_at, deleted_at;
+
+-- name: PurgeAuthor :execrows
 DELETE
 FROM authors
 WHERE id = $1;
 
--- name: ListAuthors :many
-SELECT *
+-- name: SearchAuthors :many
+-- The matches in headline are delimited by STX and ETX, which are taken out
+-- of the bio, so the caller can escape it before marking them up.
+SELECT id,
+       name,
+       bio,
+       ts_rank(bio_tsv, websearch_to_tsquery('english', @query))::REAL AS rank,
+       ts_headline('english', translate(bio, chr(2) || chr(3), ''), websearch_to_tsquery('english', @query),
+                   'StartSel=' || chr(2) || ', StopSel=' || chr(3))::TEXT AS headline
 FROM authors
-ORDER BY name;
+WHERE bio_tsv @@ websearch_to_tsquery('english', @query)
+  AND deleted_at IS NULL
+ORDER BY rank DESC, id
+LIMIT @page_limit;
 
 -- name: TruncateAuthor :exec
 TRUNCATE authors;
 
+-- name: CreateAuthorAudit :exec
+INSERT INTO author_audit (author_id, action, actor, before, after)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ListAuthorAudit :many
+SELECT *
+FROM author_audit
+WHERE author_id = $1
+ORDER BY id;
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (prefix, key_hash, owner, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+    RETURNING *;
+
+-- name: GetApiKeyByHash :one
+SELECT *
+FROM api_keys
+WHERE key_hash = $1
+  AND revoked_at IS NULL
+  AND (expires_at IS NULL OR expires_at > now())
+    LIMIT 1;
+
+-- name: ListApiKeys :many
+SELECT *
+FROM api_keys
+ORDER BY id;
 
+-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1
+  AND revoked_at IS NULL
+    RETURNING *;
+
+-- name: TouchApiKey :exec
+-- Recording every use would turn each request into a write, so
+-- last_used_at is only kept to the minute.
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1
+  AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute');
+
+-- name: TakeRateLimitToken :one
+-- Refills the bucket of key and takes a token from it if there is one.
+INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES (@key, @burst::DOUBLE PRECISION - 1, TRUE, now())
+ON CONFLICT (key) DO UPDATE
+    SET allowed    = rate_limit_refill(b.tokens, b.updated_at, @rate::DOUBLE PRECISION, @burst) >= 1,
+        tokens     = rate_limit_refill(b.tokens, b.updated_at, @rate, @burst) -
+                     CASE WHEN rate_limit_refill(b.tokens, b.updated_at, @rate, @burst) >= 1 THEN 1 ELSE 0 END,
+        updated_at = now()
+    RETURNING tokens, allowed;
+
+-- name: DeleteIdleRateLimitBuckets :execrows
+DELETE
+FROM rate_limit_buckets
+WHERE updated_at < $1;
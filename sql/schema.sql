@@ -1,6 +0,0 @@
-CREATE TABLE authors
-(
-    id   BIGSERIAL PRIMARY KEY,
-    name VARCHAR(32) NOT NULL,
-    bio  TEXT        NOT NULL
-);